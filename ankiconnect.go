@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AnkiConnect is an Anki add-on which exposes the collection of a running
+// Anki through a JSON API on localhost.
+// see https://foosoft.net/projects/anki-connect/
+
+// ankiConnectURL is where AnkiConnect listens by default.
+var ankiConnectURL = "http://127.0.0.1:8765"
+
+// ankiConnectModel is the note type used for the cards. It must have the
+// fields ankiConnectFields.
+var ankiConnectModel = basicNoteType
+
+// ankiConnectClozeModel is the note type used for cloze cards. It must have the
+// fields ankiConnectClozeFields.
+var ankiConnectClozeModel = clozeNoteType
+
+// fieldNames are the fields the front and the back of a card go to.
+type fieldNames [2]string
+
+var (
+	ankiConnectFields      = fieldNames{"Front", "Back"}
+	ankiConnectClozeFields = fieldNames{"Text", "Back Extra"}
+)
+
+func (f *fieldNames) String() string { return f[0] + "," + f[1] }
+
+// Set implements flag.Value.
+func (f *fieldNames) Set(s string) error {
+	names := strings.Split(s, ",")
+	if len(names) != 2 || strings.TrimSpace(names[0]) == "" || strings.TrimSpace(names[1]) == "" {
+		return fmt.Errorf("%q are not two fields separated by a comma", s)
+	}
+	f[0], f[1] = strings.TrimSpace(names[0]), strings.TrimSpace(names[1])
+	return nil
+}
+
+const ankiConnectVersion = 6
+
+type ankiConnect struct {
+	url    string
+	client *http.Client
+	// st learns the ids of the added notes, so they can be updated and
+	// removed later.
+	st *exportState
+}
+
+func newAnkiConnect(url string) *ankiConnect {
+	return &ankiConnect{url: url, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// invoke calls action with params and decodes the result into result, if
+// result is not nil.
+func (ac *ankiConnect) invoke(action string, params, result interface{}) error {
+	req := map[string]interface{}{"action": action, "version": ankiConnectVersion}
+	if params != nil {
+		req["params"] = params
+	}
+	raw, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := ac.client.Post(ac.url, "application/json", bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ankiconnect: %s: %s", action, resp.Status)
+	}
+
+	var res struct {
+		Result json.RawMessage `json:"result"`
+		Error  *string         `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("ankiconnect: %s: %w", action, err)
+	}
+	if res.Error != nil {
+		return &ankiConnectError{action: action, msg: *res.Error}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(res.Result, result)
+}
+
+// ankiConnectError is an error AnkiConnect answered with, unlike a failed
+// request.
+type ankiConnectError struct {
+	action, msg string
+}
+
+func (e *ankiConnectError) Error() string {
+	return fmt.Sprintf("ankiconnect: %s: %s", e.action, e.msg)
+}
+
+// isNoteNotFound reports whether err tells that a note is not in the
+// collection, e.g. "Note was not found: 1714557600000".
+func isNoteNotFound(err error) bool {
+	var aerr *ankiConnectError
+	return errors.As(err, &aerr) && strings.Contains(strings.ToLower(aerr.msg), "not found")
+}
+
+// available reports whether AnkiConnect is running.
+func (ac *ankiConnect) available() bool {
+	var version int
+	return ac.invoke("version", nil, &version) == nil && version >= ankiConnectVersion
+}
+
+func (ac *ankiConnect) storeMediaFile(name string, data []byte) error {
+	return ac.invoke("storeMediaFile", map[string]interface{}{
+		"filename": name,
+		"data":     base64.StdEncoding.EncodeToString(data),
+	}, nil)
+}
+
+// storeMedia stores the files fps of the exported media folder dp under the
+// names the includeMedia mutation references them by.
+func (ac *ankiConnect) storeMedia(dp string, fps []string) (stored int, err error) {
+	for _, fp := range fps {
+		raw, err := os.ReadFile(fp)
+		if err != nil {
+			return stored, err
+		}
+		name := mediaName(dp, filepath.Base(fp))
+		if VERBOSE {
+			log.Printf("storeMediaFile %q\n", name)
+		}
+		if err := ac.storeMediaFile(name, raw); err != nil {
+			return stored, err
+		}
+		stored++
+	}
+	return stored, nil
+}
+
+type ankiConnectNote struct {
+	DeckName  string            `json:"deckName"`
+	ModelName string            `json:"modelName"`
+	Fields    map[string]string `json:"fields"`
+	Tags      []string          `json:"tags"`
+	Options   map[string]string `json:"options"`
+}
+
+func newAnkiConnectNote(c card2) ankiConnectNote {
+	tags := make([]string, len(c.tags))
+	for i := range c.tags {
+		tags[i] = string(c.tags[i])
+	}
+	model, fields := ankiConnectModel, ankiConnectFields
+	noteType, first, second := noteFields(c)
+	if noteType == clozeNoteType {
+		model, fields = ankiConnectClozeModel, ankiConnectClozeFields
+	}
+	return ankiConnectNote{
+		DeckName:  c.deck,
+		ModelName: model,
+		Fields: map[string]string{
+			fields[0]: fieldHTML(first, c.html),
+			fields[1]: fieldHTML(second, c.html),
+		},
+		Tags:    tags,
+		Options: map[string]string{"duplicateScope": "deck"},
+	}
+}
+
+// noteFailure is a card AnkiConnect refused to add, e.g. because it is a
+// duplicate or the note type is missing.
+type noteFailure struct {
+	card   card2
+	reason string
+}
+
+// addNotes adds the cards to their decks. Cards which cannot be added are
+// returned as failures, err is only set if AnkiConnect could not be used at
+// all.
+func (ac *ankiConnect) addNotes(cards []card2) (added int, failures []noteFailure, err error) {
+	notes := make([]ankiConnectNote, len(cards))
+	for i, c := range cards {
+		notes[i] = newAnkiConnectNote(c)
+	}
+
+	// find out which notes cannot be added and why, addNotes only reports
+	// that they failed.
+	var checks []struct {
+		CanAdd bool   `json:"canAdd"`
+		Error  string `json:"error"`
+	}
+	var addable []int
+	if err := ac.invoke("canAddNotesWithErrorDetail", map[string]interface{}{"notes": notes}, &checks); err != nil || len(checks) != len(notes) {
+		// older versions of AnkiConnect.
+		for i := range notes {
+			addable = append(addable, i)
+		}
+	} else {
+		for i, c := range checks {
+			if c.CanAdd {
+				addable = append(addable, i)
+				continue
+			}
+			failures = append(failures, noteFailure{card: cards[i], reason: c.Error})
+		}
+	}
+	if len(addable) == 0 {
+		return 0, failures, nil
+	}
+
+	batch := make([]ankiConnectNote, len(addable))
+	for i, k := range addable {
+		batch[i] = notes[k]
+	}
+	var ids []*int64
+	if err := ac.invoke("addNotes", map[string]interface{}{"notes": batch}, &ids); err != nil {
+		return 0, failures, err
+	}
+	for i, id := range ids {
+		if id == nil {
+			failures = append(failures, noteFailure{card: cards[addable[i]], reason: "note was not added"})
+			continue
+		}
+		ac.st.setNoteID(cards[addable[i]], *id)
+		added++
+	}
+	return added, failures, nil
+}
+
+// updateNote changes the fields and tags of the note with id to the ones of
+// card.
+func (ac *ankiConnect) updateNote(id int64, card card2) error {
+	n := newAnkiConnectNote(card)
+	if err := ac.invoke("updateNote", map[string]interface{}{"note": map[string]interface{}{
+		"id":     id,
+		"fields": n.Fields,
+		"tags":   n.Tags,
+	}}, nil); err != nil {
+		return err
+	}
+	return ac.moveNote(id, card.deck)
+}
+
+// moveNote moves the cards of the note with id which are not in deck there.
+func (ac *ankiConnect) moveNote(id int64, deck string) error {
+	var cards []int64
+	if err := ac.invoke("findCards", map[string]string{"query": "nid:" + strconv.FormatInt(id, 10)}, &cards); err != nil {
+		return err
+	}
+	var infos []struct {
+		CardID   int64  `json:"cardId"`
+		DeckName string `json:"deckName"`
+	}
+	if err := ac.invoke("cardsInfo", map[string]interface{}{"cards": cards}, &infos); err != nil {
+		return err
+	}
+	var moved []int64
+	for _, info := range infos {
+		if info.DeckName != deck {
+			moved = append(moved, info.CardID)
+		}
+	}
+	if len(moved) == 0 {
+		return nil
+	}
+	return ac.invoke("changeDeck", map[string]interface{}{"cards": moved, "deck": deck}, nil)
+}
+
+// removeNotes deletes or suspends the notes with ids.
+func (ac *ankiConnect) removeNotes(ids []int64, policy removedPolicy) error {
+	switch policy {
+	case removedDelete:
+		return ac.invoke("deleteNotes", map[string]interface{}{"notes": ids}, nil)
+	case removedSuspend:
+		query := "nid:"
+		for i, id := range ids {
+			if i > 0 {
+				query += ","
+			}
+			query += strconv.FormatInt(id, 10)
+		}
+		var cards []int64
+		if err := ac.invoke("findCards", map[string]string{"query": query}, &cards); err != nil {
+			return err
+		}
+		return ac.invoke("suspend", map[string]interface{}{"cards": cards}, nil)
+	}
+	return nil
+}
+
+// push creates the decks and adds the cards and their media files to the
+// running Anki. Cards added before, whose note id is in the export state, are
+// updated instead.
+func (ac *ankiConnect) push(mediaDps []string, cards []card2) (added, updated int, failures []noteFailure, err error) {
+	created := map[string]bool{}
+	for _, c := range cards {
+		if created[c.deck] {
+			continue
+		}
+		if err := ac.invoke("createDeck", map[string]string{"deck": c.deck}, nil); err != nil {
+			return 0, 0, nil, err
+		}
+		created[c.deck] = true
+	}
+	for _, fp := range referencedMedia(mediaDps, cards) {
+		if _, err := ac.storeMedia(filepath.Dir(fp), []string{fp}); err != nil {
+			return 0, 0, nil, err
+		}
+	}
+	var fresh []card2
+	for _, c := range cards {
+		id := ac.st.noteID(c)
+		if id == 0 {
+			fresh = append(fresh, c)
+			continue
+		}
+		if err := ac.updateNote(id, c); isNoteNotFound(err) {
+			// the note was deleted in Anki, it is added again.
+			fresh = append(fresh, c)
+			continue
+		} else if err != nil {
+			return 0, updated, nil, err
+		}
+		updated++
+	}
+	added, failures, err = ac.addNotes(fresh)
+	return added, updated, failures, err
+}
+
+// AnkiConnectSerialiser collects all cards and adds them to their decks in the
+// running Anki. Media files of mediaDps referenced by the cards are stored in
+// the collection too.
+func AnkiConnectSerialiser(ctx context.Context, url string, mediaDps []string, st *exportState, cards <-chan card2, errc chan<- error, wg *sync.WaitGroup) {
+	defer wg.Done()
+	var cs []card2
+	for card := range cards {
+		cs = append(cs, card)
+	}
+	// the cards are incomplete.
+	if ctx.Err() != nil {
+		return
+	}
+
+	ac := newAnkiConnect(url)
+	ac.st = st
+	added, updated, failures, err := ac.push(mediaDps, cs)
+	if err == nil && removedNotes != removedKeep {
+		if ids := st.removedNoteIDs(); len(ids) > 0 {
+			err = ac.removeNotes(ids, removedNotes)
+			if err == nil {
+				log.Printf("Removed %d notes of toggles removed from Notion (%s).\n", len(ids), removedNotes)
+			}
+		}
+	}
+	if err != nil {
+		report(ctx, errc, &PipelineError{Stage: stageWrite, Err: err})
+		return
+	}
+	for _, f := range failures {
+		log.Printf("Could not add %q: %s\n", f.card.front, f.reason)
+	}
+	log.Printf("Done. Added %d and updated %d of %d cards in Anki.\n", added, updated, len(cs))
+}
+
+var errNoAnkiConnect = errors.New("AnkiConnect is not running")
+
+// addMediaAnkiConnect stores all files of the exported media folder dp through
+// AnkiConnect.
+func addMediaAnkiConnect(dp string, names []string) (int, error) {
+	ac := newAnkiConnect(ankiConnectURL)
+	if !ac.available() {
+		return 0, errNoAnkiConnect
+	}
+	fps := make([]string, len(names))
+	for i, name := range names {
+		fps[i] = filepath.Join(dp, name)
+	}
+	return ac.storeMedia(dp, fps)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeAnkiConnect answers like AnkiConnect for a collection with the Basic
+// note type, which already contains a note with the front "duplicate". Every
+// note has one card with the same id.
+type fakeAnkiConnect struct {
+	mu    sync.Mutex
+	decks []string
+	media map[string][]byte
+	notes []ankiConnectNote
+	// broken makes updating notes fail like a collection which is not open.
+	broken bool
+}
+
+func (f *fakeAnkiConnect) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var req struct {
+		Action string `json:"action"`
+		Params struct {
+			Deck     string            `json:"deck"`
+			Query    string            `json:"query"`
+			Cards    []int64           `json:"cards"`
+			Filename string            `json:"filename"`
+			Data     string            `json:"data"`
+			Notes    []ankiConnectNote `json:"notes"`
+			Note     struct {
+				ID     int64             `json:"id"`
+				Fields map[string]string `json:"fields"`
+			} `json:"note"`
+		} `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	canAdd := func(n ankiConnectNote) string {
+		if n.ModelName != "Basic" {
+			return "model was not found: " + n.ModelName
+		}
+		if n.Fields["Front"] == "duplicate" {
+			return "cannot create note because it is a duplicate"
+		}
+		return ""
+	}
+
+	var result interface{}
+	switch req.Action {
+	case "version":
+		result = 6
+	case "createDeck":
+		f.decks = append(f.decks, req.Params.Deck)
+		result = 1
+	case "storeMediaFile":
+		data, _ := base64.StdEncoding.DecodeString(req.Params.Data)
+		f.media[req.Params.Filename] = data
+		result = req.Params.Filename
+	case "canAddNotesWithErrorDetail":
+		var res []map[string]interface{}
+		for _, n := range req.Params.Notes {
+			if e := canAdd(n); e != "" {
+				res = append(res, map[string]interface{}{"canAdd": false, "error": e})
+			} else {
+				res = append(res, map[string]interface{}{"canAdd": true})
+			}
+		}
+		result = res
+	case "addNotes":
+		var ids []*int64
+		for _, n := range req.Params.Notes {
+			if canAdd(n) != "" {
+				ids = append(ids, nil)
+				continue
+			}
+			f.notes = append(f.notes, n)
+			id := int64(len(f.notes))
+			ids = append(ids, &id)
+		}
+		result = ids
+	case "updateNote":
+		id := req.Params.Note.ID
+		if f.broken {
+			json.NewEncoder(w).Encode(map[string]interface{}{"result": nil, "error": "collection is not available"})
+			return
+		}
+		if id < 1 || id > int64(len(f.notes)) {
+			json.NewEncoder(w).Encode(map[string]interface{}{"result": nil, "error": "Note was not found: " + strconv.FormatInt(id, 10)})
+			return
+		}
+		f.notes[id-1].Fields = req.Params.Note.Fields
+	case "findCards":
+		id, _ := strconv.ParseInt(strings.TrimPrefix(req.Params.Query, "nid:"), 10, 64)
+		result = []int64{}
+		if id >= 1 && id <= int64(len(f.notes)) {
+			result = []int64{id}
+		}
+	case "cardsInfo":
+		var infos []map[string]interface{}
+		for _, id := range req.Params.Cards {
+			infos = append(infos, map[string]interface{}{"cardId": id, "deckName": f.notes[id-1].DeckName})
+		}
+		result = infos
+	case "changeDeck":
+		for _, id := range req.Params.Cards {
+			f.notes[id-1].DeckName = req.Params.Deck
+		}
+	default:
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": nil, "error": "unsupported action"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"result": result, "error": nil})
+}
+
+func TestAnkiConnectPush(t *testing.T) {
+	fake := &fakeAnkiConnect{media: map[string][]byte{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	dp := filepath.Join(t.TempDir(), "Page abc")
+	if err := os.Mkdir(dp, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dp, "img.png"), []byte("png"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cards := []card2{
+		{front: []byte("front"), back: []byte(`<img src="Page abc_img.png">`), tags: [][]byte{[]byte("a"), []byte("b")}, deck: "Page"},
+		{front: []byte("duplicate"), back: []byte("back"), deck: "Page"},
+	}
+	ac := newAnkiConnect(srv.URL)
+	if !ac.available() {
+		t.Fatal("fake AnkiConnect is not available")
+	}
+	added, _, failures, err := ac.push([]string{dp}, cards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 1 {
+		t.Errorf("added = %d, want 1", added)
+	}
+	if len(failures) != 1 || string(failures[0].card.front) != "duplicate" {
+		t.Errorf("failures = %v, want the duplicate", failures)
+	}
+	if len(fake.decks) != 1 || fake.decks[0] != "Page" {
+		t.Errorf("decks = %v", fake.decks)
+	}
+	if string(fake.media["Page abc_img.png"]) != "png" {
+		t.Errorf("media = %v", fake.media)
+	}
+	if n := fake.notes[0]; n.DeckName != "Page" || len(n.Tags) != 2 {
+		t.Errorf("note = %+v", n)
+	}
+}
+
+func TestAnkiConnectMissingModel(t *testing.T) {
+	srv := httptest.NewServer(&fakeAnkiConnect{media: map[string][]byte{}})
+	defer srv.Close()
+
+	defer func(model string) { ankiConnectModel = model }(ankiConnectModel)
+	ankiConnectModel = "Missing"
+
+	added, _, failures, err := newAnkiConnect(srv.URL).push([]string{t.TempDir()}, []card2{{front: []byte("a")}, {front: []byte("b")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 0 || len(failures) != 2 {
+		t.Errorf("added = %d, failures = %d, want 0 and 2", added, len(failures))
+	}
+}
+
+func TestAnkiConnectUpdate(t *testing.T) {
+	fake := &fakeAnkiConnect{media: map[string][]byte{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	st, err := openState(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	card := card2{front: []byte("front"), back: []byte("back"), guid: "a", toggle: "a", deck: "Page"}
+	st.classify(card, "1")
+	st.written(card)
+	ac := newAnkiConnect(srv.URL)
+	ac.st = st
+	if added, updated, _, err := ac.push(nil, []card2{card}); err != nil || added != 1 || updated != 0 {
+		t.Fatalf("first push: added %d, updated %d, err %v", added, updated, err)
+	}
+	if err := st.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the next run knows the note.
+	if st, err = openState(st.fp); err != nil {
+		t.Fatal(err)
+	}
+	card.back = []byte("changed")
+	st.classify(card, "2")
+	st.written(card)
+	ac.st = st
+	if added, updated, _, err := ac.push(nil, []card2{card}); err != nil || added != 0 || updated != 1 {
+		t.Fatalf("second push: added %d, updated %d, err %v", added, updated, err)
+	}
+	if len(fake.notes) != 1 || fake.notes[0].Fields["Back"] != "changed" {
+		t.Errorf("notes = %+v, want the updated note", fake.notes)
+	}
+
+	// the note moves with the card to another deck.
+	card.deck = "Page::Other"
+	if _, updated, _, err := ac.push(nil, []card2{card}); err != nil || updated != 1 || fake.notes[0].DeckName != "Page::Other" {
+		t.Errorf("push to another deck: updated %d, err %v, note in %q", updated, err, fake.notes[0].DeckName)
+	}
+
+	// only a missing note is added again, not one which failed to update.
+	fake.broken = true
+	if added, _, _, err := ac.push(nil, []card2{card}); err == nil || added != 0 || len(fake.notes) != 1 {
+		t.Errorf("failed update: added %d, err %v, %d notes", added, err, len(fake.notes))
+	}
+	fake.broken = false
+	fake.notes = nil
+	if added, updated, _, err := ac.push(nil, []card2{card}); err != nil || added != 1 || updated != 0 {
+		t.Errorf("deleted note: added %d, updated %d, err %v", added, updated, err)
+	}
+}
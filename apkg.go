@@ -0,0 +1,471 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An .apkg file is a zip archive containing
+//   - collection.anki2: the SQLite collection with the deck, note type, notes and cards,
+//   - 0, 1, 2, ...: the media files,
+//   - media: a JSON object mapping the numbered files to their real names.
+// see https://github.com/ankitects/anki/blob/main/rslib/src/storage/schema11.sql
+
+func MdToApkgFilename(fp string) string {
+	return outputName(fp) + ".apkg"
+}
+
+// mediaDir returns the folder Notion exports the media files of the page fp
+// into.
+func mediaDir(fp string) string {
+	return fp[:len(fp)-len(filepath.Ext(fp))]
+}
+
+// mediaName returns the name a media file of the exported media folder dp is
+// referenced by after the includeMedia mutation.
+func mediaName(dp, name string) string {
+	return filepath.Base(dp) + "_" + name
+}
+
+// ApkgSerialiser collects all cards and writes them with their decks into the
+// .apkg package fp. Media files of mediaDps referenced by the cards are
+// packaged too.
+func ApkgSerialiser(ctx context.Context, fp string, mediaDps []string, cards <-chan card2, errc chan<- error, wg *sync.WaitGroup) {
+	defer wg.Done()
+	var cs []card2
+	for card := range cards {
+		cs = append(cs, card)
+	}
+	// the cards are incomplete.
+	if ctx.Err() != nil {
+		return
+	}
+
+	if err := writeApkgFile(fp, mediaDps, cs); err != nil {
+		os.Remove(fp)
+		report(ctx, errc, &PipelineError{Stage: stageWrite, Err: err})
+		return
+	}
+	log.Printf("Done. Added %d cards to %q.\n", len(cs), fp)
+}
+
+func writeApkgFile(fp string, mediaDps []string, cards []card2) (err error) {
+	out, err := os.OpenFile(fp, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer closeFile(out, &err)
+	return writeApkg(out, mediaDps, cards)
+}
+
+func writeApkg(w io.Writer, mediaDps []string, cards []card2) error {
+	var col bytes.Buffer
+	if err := writeSQLite(&col, newCollection(cards)); err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	f, err := zw.Create("collection.anki2")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(col.Bytes()); err != nil {
+		return err
+	}
+
+	media := map[string]string{}
+	for i, fp := range referencedMedia(mediaDps, cards) {
+		raw, err := os.ReadFile(fp)
+		if err != nil {
+			return err
+		}
+		key := strconv.Itoa(i)
+		f, err := zw.Create(key)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(raw); err != nil {
+			return err
+		}
+		media[key] = mediaName(filepath.Dir(fp), filepath.Base(fp))
+	}
+	f, err = zw.Create("media")
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(media); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// referencedMedia returns the files of the media folders dps which are used by
+// any of the cards.
+func referencedMedia(dps []string, cards []card2) []string {
+	var fps []string
+	for _, dp := range dps {
+		dir, err := os.Open(dp)
+		if err != nil {
+			continue
+		}
+		names, err := dir.Readdirnames(-1)
+		dir.Close()
+		if err != nil {
+			continue
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			ref := []byte(mediaName(dp, name))
+			for _, c := range cards {
+				if bytes.Contains(c.front, ref) || bytes.Contains(c.back, ref) {
+					fps = append(fps, filepath.Join(dp, name))
+					break
+				}
+			}
+		}
+	}
+	return fps
+}
+
+const (
+	basicModelName = "md2anki Basic"
+	clozeModelName = "md2anki Cloze"
+	// defaultDeckID is the "Default" deck every collection has.
+	defaultDeckID = 1
+)
+
+var basicModelCSS = `.card {
+  font-family: arial;
+  font-size: 20px;
+  text-align: left;
+  color: black;
+  background-color: white;
+}
+`
+
+// newCollection returns the tables of a schema 11 Anki collection holding the
+// cards and their decks.
+func newCollection(cards []card2) []*sqliteTable {
+	now := time.Now()
+	mod := now.Unix()
+	decks := map[string]interface{}{
+		strconv.Itoa(defaultDeckID): newDeck(defaultDeckID, "Default", mod),
+	}
+	did := int64(defaultDeckID) // the current deck
+	deckID := func(name string) int64 {
+		if name == "" {
+			return defaultDeckID
+		}
+		id := stableID(name)
+		if _, ok := decks[strconv.FormatInt(id, 10)]; ok {
+			return id
+		}
+		// parents of subdecks must exist too.
+		parts := strings.Split(name, "::")
+		for i := range parts {
+			parent := strings.Join(parts[:i+1], "::")
+			pid := stableID(parent)
+			decks[strconv.FormatInt(pid, 10)] = newDeck(pid, parent, mod)
+		}
+		return id
+	}
+	mid := stableID(basicModelName)
+	clozeMid := stableID(clozeModelName)
+
+	notes := &sqliteTable{
+		name: "notes",
+		sql:  "CREATE TABLE notes (id integer primary key, guid text not null, mid integer not null, mod integer not null, usn integer not null, tags text not null, flds text not null, sfld integer not null, csum integer not null, flags integer not null, data text not null)",
+		pk:   true,
+		indexes: []sqliteIndex{
+			{name: "ix_notes_usn", sql: "CREATE INDEX ix_notes_usn on notes (usn)", cols: []int{4}},
+			{name: "ix_notes_csum", sql: "CREATE INDEX ix_notes_csum on notes (csum)", cols: []int{8}},
+		},
+	}
+	cardsTable := &sqliteTable{
+		name: "cards",
+		sql:  "CREATE TABLE cards (id integer primary key, nid integer not null, did integer not null, ord integer not null, mod integer not null, usn integer not null, type integer not null, queue integer not null, due integer not null, ivl integer not null, factor integer not null, reps integer not null, lapses integer not null, left integer not null, odue integer not null, odid integer not null, flags integer not null, data text not null)",
+		pk:   true,
+		indexes: []sqliteIndex{
+			{name: "ix_cards_usn", sql: "CREATE INDEX ix_cards_usn on cards (usn)", cols: []int{5}},
+			{name: "ix_cards_nid", sql: "CREATE INDEX ix_cards_nid on cards (nid)", cols: []int{1}},
+			{name: "ix_cards_sched", sql: "CREATE INDEX ix_cards_sched on cards (did, queue, due)", cols: []int{2, 7, 8}},
+		},
+	}
+
+	base := now.UnixNano() / int64(time.Millisecond)
+	cid := base
+	for i, c := range cards {
+		id := base + int64(i)
+		cdid := deckID(c.deck)
+		if i == 0 {
+			did = cdid
+		}
+		noteType, first, second := noteFields(c)
+		front, back := fieldHTML(first, c.html), fieldHTML(second, c.html)
+		guid := c.guid
+		if guid == "" {
+			guid = newGUID()
+		}
+		noteMid, ords := mid, []int{1}
+		if noteType == clozeNoteType {
+			noteMid, ords = clozeMid, clozeOrds(c)
+		}
+		notes.rows = append(notes.rows, []interface{}{
+			id, guid, noteMid, mod, -1,
+			noteTags(c.tags),
+			front + "\x1f" + back,
+			stripHTML(front),
+			checksum(stripHTML(front)),
+			0, "",
+		})
+		// a cloze note has a card for every cloze number.
+		for _, ord := range ords {
+			cardsTable.rows = append(cardsTable.rows, []interface{}{
+				cid, id, cdid, ord - 1, mod, -1,
+				0, 0, i + 1, // new card, due is the position in the new queue.
+				0, 0, 0, 0, 0, 0, 0, 0, "",
+			})
+			cid++
+		}
+	}
+
+	col := &sqliteTable{
+		name: "col",
+		sql:  "CREATE TABLE col (id integer primary key, crt integer not null, mod integer not null, scm integer not null, ver integer not null, dty integer not null, usn integer not null, ls integer not null, conf text not null, models text not null, decks text not null, dconf text not null, tags text not null)",
+		pk:   true,
+		rows: [][]interface{}{{
+			1, mod, mod * 1000, mod * 1000, 11, 0, 0, 0,
+			mustJSON(colConf(did, mid)),
+			mustJSON(map[string]interface{}{
+				strconv.FormatInt(mid, 10):      basicModel(mid, did, mod),
+				strconv.FormatInt(clozeMid, 10): clozeModel(clozeMid, did, mod),
+			}),
+			mustJSON(decks),
+			mustJSON(map[string]interface{}{"1": defaultDeckConf(mod)}),
+			"{}",
+		}},
+	}
+	revlog := &sqliteTable{
+		name: "revlog",
+		sql:  "CREATE TABLE revlog (id integer primary key, cid integer not null, usn integer not null, ease integer not null, ivl integer not null, lastIvl integer not null, factor integer not null, time integer not null, type integer not null)",
+		pk:   true,
+		indexes: []sqliteIndex{
+			{name: "ix_revlog_usn", sql: "CREATE INDEX ix_revlog_usn on revlog (usn)", cols: []int{2}},
+			{name: "ix_revlog_cid", sql: "CREATE INDEX ix_revlog_cid on revlog (cid)", cols: []int{1}},
+		},
+	}
+	graves := &sqliteTable{
+		name: "graves",
+		sql:  "CREATE TABLE graves (usn integer not null, oid integer not null, type integer not null)",
+	}
+	return []*sqliteTable{col, notes, cardsTable, revlog, graves}
+}
+
+func colConf(did, mid int64) map[string]interface{} {
+	return map[string]interface{}{
+		"nextPos":       1,
+		"estTimes":      true,
+		"activeDecks":   []int64{did},
+		"sortType":      "noteFld",
+		"timeLim":       0,
+		"sortBackwards": false,
+		"addToCur":      true,
+		"curDeck":       did,
+		"newBury":       true,
+		"newSpread":     0,
+		"dueCounts":     true,
+		"curModel":      strconv.FormatInt(mid, 10),
+		"collapseTime":  1200,
+	}
+}
+
+func basicModel(mid, did, mod int64) map[string]interface{} {
+	m := newModel(mid, did, mod, basicModelName, "Front", "Back")
+	m["tmpls"] = []interface{}{newTemplate(
+		"{{Front}}",
+		"{{FrontSide}}\n\n<hr id=answer>\n\n{{Back}}",
+	)}
+	m["req"] = []interface{}{[]interface{}{0, "any", []int{0}}}
+	return m
+}
+
+func clozeModel(mid, did, mod int64) map[string]interface{} {
+	m := newModel(mid, did, mod, clozeModelName, "Text", "Back Extra")
+	m["type"] = 1
+	m["tmpls"] = []interface{}{newTemplate(
+		"{{cloze:Text}}",
+		"{{cloze:Text}}<br>\n{{Back Extra}}",
+	)}
+	m["css"] = basicModelCSS + ".cloze {\n  font-weight: bold;\n  color: blue;\n}\n"
+	return m
+}
+
+func newModel(mid, did, mod int64, name string, fields ...string) map[string]interface{} {
+	var flds []interface{}
+	for ord, name := range fields {
+		flds = append(flds, map[string]interface{}{
+			"name": name, "ord": ord, "sticky": false, "rtl": false,
+			"font": "Arial", "size": 20, "media": []string{},
+		})
+	}
+	return map[string]interface{}{
+		"id":        mid,
+		"name":      name,
+		"type":      0,
+		"mod":       mod,
+		"usn":       -1,
+		"sortf":     0,
+		"did":       did,
+		"flds":      flds,
+		"css":       basicModelCSS,
+		"latexPre":  "\\documentclass[12pt]{article}\n\\special{papersize=3in,5in}\n\\usepackage[utf8]{inputenc}\n\\usepackage{amssymb,amsmath}\n\\pagestyle{empty}\n\\setlength{\\parindent}{0in}\n\\begin{document}\n",
+		"latexPost": "\\end{document}",
+		"latexsvg":  false,
+		"tags":      []string{},
+		"vers":      []int{},
+	}
+}
+
+func newTemplate(qfmt, afmt string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":  "Card 1",
+		"ord":   0,
+		"qfmt":  qfmt,
+		"afmt":  afmt,
+		"did":   nil,
+		"bqfmt": "",
+		"bafmt": "",
+	}
+}
+
+func newDeck(did int64, name string, mod int64) map[string]interface{} {
+	return map[string]interface{}{
+		"id":               did,
+		"name":             name,
+		"mod":              mod,
+		"usn":              -1,
+		"lrnToday":         []int{0, 0},
+		"revToday":         []int{0, 0},
+		"newToday":         []int{0, 0},
+		"timeToday":        []int{0, 0},
+		"collapsed":        false,
+		"browserCollapsed": false,
+		"desc":             "",
+		"dyn":              0,
+		"conf":             1,
+		"extendNew":        0,
+		"extendRev":        0,
+	}
+}
+
+func defaultDeckConf(mod int64) map[string]interface{} {
+	return map[string]interface{}{
+		"id":       1,
+		"name":     "Default",
+		"mod":      mod,
+		"usn":      -1,
+		"maxTaken": 60,
+		"autoplay": true,
+		"timer":    0,
+		"replayq":  true,
+		"dyn":      false,
+		"new": map[string]interface{}{
+			"bury":          false,
+			"delays":        []float64{1, 10},
+			"initialFactor": 2500,
+			"ints":          []int{1, 4, 0},
+			"order":         1,
+			"perDay":        20,
+		},
+		"rev": map[string]interface{}{
+			"bury":       false,
+			"ease4":      1.3,
+			"ivlFct":     1,
+			"maxIvl":     36500,
+			"perDay":     200,
+			"hardFactor": 1.2,
+		},
+		"lapse": map[string]interface{}{
+			"delays":      []float64{10},
+			"leechAction": 1,
+			"leechFails":  8,
+			"minInt":      1,
+			"mult":        0,
+		},
+	}
+}
+
+// stableID derives a positive id from name, so the same deck or note type
+// gets the same id on every export.
+func stableID(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return 1<<30 + int64(h.Sum64()%(1<<30))
+}
+
+func newGUID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+// noteTags returns the tags in the format of the notes table: separated and
+// surrounded by a space.
+func noteTags(tags [][]byte) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	return " " + string(bytes.Join(tags, []byte{' '})) + " "
+}
+
+// fieldHTML turns the plain text of a card side into an HTML field. The text
+// import does the same when "Allow HTML" is ticked. Sides of cards from the
+// HTML export are HTML already.
+func fieldHTML(text []byte, isHTML bool) string {
+	s := strings.TrimRight(string(text), "\n")
+	if isHTML {
+		return s
+	}
+	return strings.ReplaceAll(s, "\n", "<br>")
+}
+
+var htmlTagExp = regexp.MustCompile(`<[^>]*>`)
+
+func stripHTML(s string) string {
+	return htmlTagExp.ReplaceAllString(s, "")
+}
+
+// checksum is the first 8 hex digits of the sha1 of the sort field as an
+// integer, which Anki uses to find duplicates.
+func checksum(s string) int64 {
+	sum := sha1.Sum([]byte(s))
+	return int64(binary.BigEndian.Uint32(sum[:4]))
+}
+
+func mustJSON(v interface{}) string {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return string(raw)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAppendVarint(t *testing.T) {
+	tests := []struct {
+		v    uint64
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{0x7f, []byte{0x7f}},
+		{0x80, []byte{0x81, 0x00}},
+		{0x3fff, []byte{0xff, 0x7f}},
+		{0x4000, []byte{0x81, 0x80, 0x00}},
+		{1<<64 - 1, bytes.Repeat([]byte{0xff}, 9)},
+	}
+	for _, tt := range tests {
+		if got := appendVarint(nil, tt.v); !bytes.Equal(got, tt.want) {
+			t.Errorf("appendVarint(%#x) = %x, want %x", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestWriteApkg(t *testing.T) {
+	dp := filepath.Join(t.TempDir(), "Page abc")
+	if err := os.Mkdir(dp, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"used.png", "unused.png"} {
+		if err := os.WriteFile(filepath.Join(dp, name), []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cards := []card2{
+		{front: []byte("front"), back: []byte(`<img src="Page abc_used.png">`), tags: [][]byte{[]byte("a")}, deck: "Page"},
+		{front: []byte("second"), back: []byte("back\n"), deck: "Page::Child"},
+	}
+
+	var buf bytes.Buffer
+	if err := writeApkg(&buf, []string{dp}, cards); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], _ = io.ReadAll(r)
+		r.Close()
+	}
+
+	col := files["collection.anki2"]
+	if !bytes.HasPrefix(col, []byte("SQLite format 3\x00")) || len(col)%sqlitePageSize != 0 {
+		t.Errorf("collection.anki2 is not a SQLite database")
+	}
+	var media map[string]string
+	if err := json.Unmarshal(files["media"], &media); err != nil {
+		t.Fatal(err)
+	}
+	if len(media) != 1 || media["0"] != "Page abc_used.png" {
+		t.Errorf("media = %v, want only the referenced file", media)
+	}
+	if string(files["0"]) != "used.png" {
+		t.Errorf("media file 0 = %q", files["0"])
+	}
+}
@@ -3,7 +3,7 @@
 # This is ment for developers, not users.
 
 # build for linux and darwin
-GOOS=linux GOARCH=amd64 go build -o md2anki main.go toggle.go
+GOOS=linux GOARCH=amd64 go build -o md2anki main.go toggle.go markdown.go apkg.go sqlite.go ankiconnect.go cloze.go lint.go workspace.go html.go nested.go render.go highlight.go journal.go prompt.go editor.go review.go tui.go web.go watch.go state.go config.go tags.go
 
 # build for windows
-GOOS=windows GOARCH=amd64 go build -o md2anki.exe main.go toggle.go
+GOOS=windows GOARCH=amd64 go build -o md2anki.exe main.go toggle.go markdown.go apkg.go sqlite.go ankiconnect.go cloze.go lint.go workspace.go html.go nested.go render.go highlight.go journal.go prompt.go editor.go review.go tui.go web.go watch.go state.go config.go tags.go
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// Cloze notes hide parts of a text, every {{c1::...}} becomes one card.
+// A toggle turns into a cloze note if it contains Anki's cloze syntax, or if
+// its front starts with clozeMarker. Then all the bold text of the toggle is
+// turned into cloze deletions:
+/*
+- Cloze: Photosynthesis
+
+	Plants turn **light** into **chemical energy**.
+
+|
+V
+
+Text: Photosynthesis
+      Plants turn {{c1::light}} into {{c2::chemical energy}}.
+*/
+// If the front itself contains a deletion, the front is the text and the back
+// goes into the "Back Extra" field.
+
+// clozeMarker starts the front of toggles whose bold text should become cloze
+// deletions, e.g. "cloze:". It is matched case insensitively. Empty, the
+// default, leaves bold text alone.
+var clozeMarker string
+
+var (
+	clozeExp = regexp.MustCompile(`\{\{c(\d+)::`)
+	boldExp  = regexp.MustCompile(`\*\*(.+?)\*\*`)
+)
+
+const (
+	basicNoteType = "Basic"
+	clozeNoteType = "Cloze"
+)
+
+// isCloze reports whether c must be added as a cloze note.
+func isCloze(c card2) bool {
+	return c.cloze || clozeExp.Match(c.front) || clozeExp.Match(c.back)
+}
+
+// markCloze turns the bold text of a toggle starting with clozeMarker into
+// numbered cloze deletions.
+func markCloze(c *card2) {
+	if clozeMarker == "" || len(c.front) < len(clozeMarker) ||
+		!bytes.EqualFold(c.front[:len(clozeMarker)], []byte(clozeMarker)) {
+		return
+	}
+	c.front = bytes.TrimSpace(c.front[len(clozeMarker):])
+	c.cloze = true
+
+	// continue the numbering of deletions already present.
+	n := 0
+	for _, ord := range clozeOrds(*c) {
+		if ord > n {
+			n = ord
+		}
+	}
+	replace := func(b []byte) []byte {
+		return boldExp.ReplaceAllFunc(b, func(m []byte) []byte {
+			n++
+			inner := boldExp.FindSubmatch(m)[1]
+			return []byte("{{c" + strconv.Itoa(n) + "::" + string(inner) + "}}")
+		})
+	}
+	c.front = replace(c.front)
+	c.back = replace(c.back)
+}
+
+// clozeOrds returns the distinct cloze numbers of c in ascending order. Anki
+// creates one card for every number.
+func clozeOrds(c card2) []int {
+	seen := map[int]bool{}
+	var ords []int
+	for _, b := range [][]byte{c.front, c.back} {
+		for _, m := range clozeExp.FindAllSubmatch(b, -1) {
+			n, err := strconv.Atoi(string(m[1]))
+			if err != nil || n < 1 || seen[n] {
+				continue
+			}
+			seen[n] = true
+			ords = append(ords, n)
+		}
+	}
+	sort.Ints(ords)
+	return ords
+}
+
+// noteFields returns the note type of c and its two fields: Front and Back
+// for basic notes, Text and Back Extra for cloze notes.
+func noteFields(c card2) (noteType string, first, second []byte) {
+	if !isCloze(c) {
+		return basicNoteType, c.front, c.back
+	}
+	if clozeExp.Match(c.front) || len(bytes.TrimSpace(c.back)) == 0 {
+		return clozeNoteType, c.front, c.back
+	}
+	if len(bytes.TrimSpace(c.front)) == 0 {
+		return clozeNoteType, c.back, nil
+	}
+	text := append(append([]byte{}, c.front...), '\n')
+	return clozeNoteType, append(text, c.back...), nil
+}
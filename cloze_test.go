@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarkCloze(t *testing.T) {
+	clozeMarker = "cloze:"
+	defer func() { clozeMarker = "" }()
+	c := card2{
+		front: []byte("Cloze: Photosynthesis"),
+		back:  []byte("Plants turn **light** into **chemical energy** in {{c1::chloroplasts}}.\n"),
+	}
+	markCloze(&c)
+
+	noteType, text, extra := noteFields(c)
+	if noteType != clozeNoteType {
+		t.Fatalf("note type = %q, want %q", noteType, clozeNoteType)
+	}
+	want := "Photosynthesis\nPlants turn {{c2::light}} into {{c3::chemical energy}} in {{c1::chloroplasts}}.\n"
+	if string(text) != want {
+		t.Errorf("text = %q, want %q", text, want)
+	}
+	if len(extra) != 0 {
+		t.Errorf("back extra = %q, want empty", extra)
+	}
+	if ords := clozeOrds(c); !reflect.DeepEqual(ords, []int{1, 2, 3}) {
+		t.Errorf("ords = %v", ords)
+	}
+}
+
+func TestNoteFields(t *testing.T) {
+	clozeMarker = "cloze:"
+	defer func() { clozeMarker = "" }()
+	tests := []struct {
+		name     string
+		card     card2
+		noteType string
+		first    string
+		second   string
+	}{
+		{"basic", card2{front: []byte("**front**"), back: []byte("back")}, basicNoteType, "**front**", "back"},
+		{"cloze in front", card2{front: []byte("{{c1::Paris}} is the capital of France"), back: []byte("extra")}, clozeNoteType, "{{c1::Paris}} is the capital of France", "extra"},
+		{"unmarked bold", card2{front: []byte("front"), back: []byte("**no cloze**")}, basicNoteType, "front", "**no cloze**"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			markCloze(&tt.card)
+			noteType, first, second := noteFields(tt.card)
+			if noteType != tt.noteType || string(first) != tt.first || string(second) != tt.second {
+				t.Errorf("noteFields = %q, %q, %q, want %q, %q, %q", noteType, first, second, tt.noteType, tt.first, tt.second)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Settings are read from a config file in a small subset of TOML, first from
+// md2anki/config.toml in the user's config folder (~/.config on Linux), then
+// from md2anki.toml in the current folder, which overrides it:
+/*
+# every run
+math = true
+editor = "code --wait"
+
+[profiles.spanish]
+html = true
+anki-connect = true
+note-type = "Basic (and reversed card)"
+fields = ["Front", "Back"]
+tags = "none"
+*/
+// Every setting is named after a flag and takes the same values, "_" may be
+// written for "-". The settings on top apply to all runs, a profile is only
+// applied when it is picked with -profile. Flags on the command line win.
+
+// profileName is the profile of the config file applied to this run.
+var profileName string
+
+// localConfig is the config file of the current folder.
+const localConfig = "md2anki.toml"
+
+type setting struct {
+	value string
+	pos   string // file and line, for errors.
+}
+
+// config holds the settings on top in "" and the ones of each profile by its
+// name.
+type config map[string]map[string]setting
+
+// configFiles returns the config files in the order they are applied.
+func configFiles() []string {
+	var fps []string
+	if dp, err := os.UserConfigDir(); err == nil {
+		fps = append(fps, filepath.Join(dp, "md2anki", "config.toml"))
+	}
+	return append(fps, localConfig)
+}
+
+// loadConfig reads the config files fps. Missing files are left out.
+func loadConfig(fps ...string) (config, error) {
+	c := config{}
+	for _, fp := range fps {
+		raw, err := os.ReadFile(fp)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := c.parse(fp, string(raw)); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// parse adds the settings of the TOML document raw read from fp.
+func (c config) parse(fp, raw string) error {
+	section := ""
+	for i, line := range strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n") {
+		pos := fmt.Sprintf("%s:%d", fp, i+1)
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			name := strings.TrimSpace(strings.TrimSuffix(stripComment(line), "]"))
+			name = strings.TrimSpace(strings.TrimPrefix(name, "["))
+			profile, ok := strings.CutPrefix(name, "profiles.")
+			if !ok || profile == "" {
+				return fmt.Errorf("%s: unknown table [%s], use [profiles.name]", pos, name)
+			}
+			section = strings.Trim(profile, `"`)
+			if c[section] == nil {
+				c[section] = map[string]setting{}
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("%s: %q is no setting, write it as name = value", pos, line)
+		}
+		key = strings.ReplaceAll(strings.TrimSpace(key), "_", "-")
+		v, err := parseTOMLValue(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%s: %s: %v", pos, key, err)
+		}
+		if c[section] == nil {
+			c[section] = map[string]setting{}
+		}
+		c[section][key] = setting{value: v, pos: pos}
+	}
+	return nil
+}
+
+// parseTOMLValue returns a string, boolean, number or an array of them as the
+// text a flag takes. The items of an array are joined by commas.
+func parseTOMLValue(s string) (string, error) {
+	if strings.HasPrefix(s, "[") {
+		var items []string
+		rest := strings.TrimSpace(s[1:])
+		for {
+			if strings.HasPrefix(rest, "]") {
+				if t := stripComment(rest[1:]); strings.TrimSpace(t) != "" {
+					return "", fmt.Errorf("unexpected %q after the array", t)
+				}
+				return strings.Join(items, ","), nil
+			}
+			item, n, err := parseTOMLScalar(rest)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, item)
+			rest = strings.TrimSpace(rest[n:])
+			if r, ok := strings.CutPrefix(rest, ","); ok {
+				rest = strings.TrimSpace(r)
+			} else if !strings.HasPrefix(rest, "]") {
+				return "", errors.New("the array must end with ] on the same line")
+			}
+		}
+	}
+	v, n, err := parseTOMLScalar(s)
+	if err != nil {
+		return "", err
+	}
+	if t := stripComment(s[n:]); strings.TrimSpace(t) != "" {
+		return "", fmt.Errorf("unexpected %q after the value", t)
+	}
+	return v, nil
+}
+
+// parseTOMLScalar parses the value s starts with and returns its length in s.
+func parseTOMLScalar(s string) (v string, n int, err error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '"':
+				return b.String(), i + 1, nil
+			case '\\':
+				if i+1 == len(s) {
+					return "", 0, errors.New("unterminated string")
+				}
+				i++
+				switch s[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				case '"', '\\':
+					b.WriteByte(s[i])
+				default:
+					return "", 0, fmt.Errorf("unknown escape \\%c", s[i])
+				}
+			default:
+				b.WriteByte(s[i])
+			}
+		}
+		return "", 0, errors.New("unterminated string")
+	case strings.HasPrefix(s, "'"):
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", 0, errors.New("unterminated string")
+		}
+		return s[1 : end+1], end + 2, nil
+	}
+	n = strings.IndexAny(s, " \t,]#")
+	if n < 0 {
+		n = len(s)
+	}
+	v = s[:n]
+	if _, err := strconv.ParseFloat(v, 64); err != nil && v != "true" && v != "false" {
+		return "", 0, fmt.Errorf("%q is no value, quote strings", v)
+	}
+	return v, n, nil
+}
+
+// stripComment removes a # comment, s must not hold a string.
+func stripComment(s string) string {
+	if i := strings.IndexByte(s, '#'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// apply sets the flags of fs to the settings on top and the ones of profile.
+// Flags set on the command line are kept.
+func (c config) apply(fs *flag.FlagSet, profile string) error {
+	if profile != "" && c[profile] == nil {
+		var names []string
+		for name := range c {
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		return fmt.Errorf("there is no profile %q in the config files, there are %v", profile, names)
+	}
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	sections := []string{""}
+	if profile != "" {
+		sections = append(sections, profile)
+	}
+	for _, section := range sections {
+		settings := c[section]
+		keys := make([]string, 0, len(settings))
+		for key := range settings {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := settings[key]
+			if key == "profile" || fs.Lookup(key) == nil {
+				return fmt.Errorf("%s: unknown setting %q", s.pos, key)
+			}
+			if given[key] {
+				continue
+			}
+			if err := fs.Set(key, s.value); err != nil {
+				return fmt.Errorf("%s: %s: %v", s.pos, key, err)
+			}
+		}
+	}
+	return nil
+}
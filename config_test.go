@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	dp := t.TempDir()
+	global := filepath.Join(dp, "config.toml")
+	local := filepath.Join(dp, "md2anki.toml")
+	if err := os.WriteFile(global, []byte(`# defaults
+math = true
+editor = "code --wait" # a comment
+deck = 'Global'
+
+[profiles.spanish]
+html = true
+fields = ["Frente", "Reverso"]
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(local, []byte("deck = \"Local \\\"#1\\\"\"\n[profiles.spanish]\nheading_depth = 2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadConfig(global, local, filepath.Join(dp, "missing.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("md2anki", flag.ContinueOnError)
+	math := fs.Bool("math", false, "")
+	html := fs.Bool("html", false, "")
+	editor := fs.String("editor", "", "")
+	deck := fs.String("deck", "", "")
+	depth := fs.Int("heading-depth", 3, "")
+	fields := fieldNames{"Front", "Back"}
+	fs.Var(&fields, "fields", "")
+	fs.String("profile", "", "")
+	if err := fs.Parse([]string{"-editor", "vim"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.apply(fs, "spanish"); err != nil {
+		t.Fatal(err)
+	}
+	if !*math || !*html || *editor != "vim" || *deck != `Local "#1"` || *depth != 2 || fields != (fieldNames{"Frente", "Reverso"}) {
+		t.Errorf("got math=%v html=%v editor=%q deck=%q depth=%d fields=%v", *math, *html, *editor, *deck, *depth, fields)
+	}
+
+	if err := c.apply(fs, "french"); err == nil || !strings.Contains(err.Error(), "spanish") {
+		t.Errorf("missing profile: err = %v", err)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	for _, raw := range []string{
+		"math\n",
+		"editor = vim\n",
+		"editor = \"vim\n",
+		"[decks]\n",
+		"fields = [\"a\",\n",
+		"math = true false\n",
+	} {
+		if err := (config{}).parse("config.toml", raw); err == nil || !strings.HasPrefix(err.Error(), "config.toml:1: ") {
+			t.Errorf("parse(%q) = %v, want an error on line 1", raw, err)
+		}
+	}
+
+	c := config{}
+	if err := c.parse("config.toml", "\nmaths = true\n"); err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("md2anki", flag.ContinueOnError)
+	fs.Bool("math", false, "")
+	if err := c.apply(fs, ""); err == nil || !strings.Contains(err.Error(), `config.toml:2: unknown setting "maths"`) {
+		t.Errorf("unknown setting: err = %v", err)
+	}
+}
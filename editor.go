@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// The editor is looked up in this order: the -editor flag, $VISUAL, $EDITOR
+// and finally a default of the platform. It may have arguments, which are
+// split like a shell does, e.g. `code --wait` or `"/opt/My Editor/edit" -n`.
+// The editor gets the terminal md2anki runs in, so vim, emacs -nw and others
+// work like they do in a shell.
+
+// editorCommand overrides $VISUAL and $EDITOR.
+var editorCommand string
+
+var (
+	windowsEditor = "notepad.exe"
+	unixEditor    = "nano"
+)
+
+// return a string array which can be passed to exec.Command([0], [1:]...)
+func getCmd(fp string) ([]string, error) {
+	editor := editorCommand
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if editor == "" {
+			editor = os.Getenv(env)
+		}
+	}
+	if editor == "" {
+		switch runtime.GOOS {
+		case "windows":
+			editor = windowsEditor
+		case "linux", "darwin":
+			editor = unixEditor
+		default:
+			return nil, fmt.Errorf("%s is not a supported platform", runtime.GOOS)
+		}
+	}
+	args, err := splitArgs(editor)
+	if err != nil {
+		return nil, fmt.Errorf("editor %q: %v", editor, err)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("the editor is empty")
+	}
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return nil, err
+	}
+	args[0] = path
+	return append(args, fp), nil
+}
+
+// splitArgs splits the command line s into arguments at blanks outside of
+// quotes. Backslashes escape the next character, except in single quotes.
+func splitArgs(s string) ([]string, error) {
+	var (
+		args  []string
+		arg   strings.Builder
+		inArg bool
+		quote rune
+	)
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+				continue
+			}
+		case r == '\\' && i+1 < len(rs) && (quote == 0 || rs[i+1] == '"' || rs[i+1] == '\\'):
+			i++
+			r = rs[i]
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+				continue
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+			continue
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+			continue
+		}
+		arg.WriteRune(r)
+		inArg = true
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unclosed %c", quote)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// Blocks until the editor is closed. An editor exiting with an error fails the
+// review, because the card may not have been saved.
+func runCommand(exe *exec.Cmd) error {
+	exe.Stdin, exe.Stdout, exe.Stderr = os.Stdin, os.Stdout, os.Stderr
+	// the controlling terminal, even if the input of md2anki is redirected.
+	if runtime.GOOS != "windows" {
+		if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+			defer tty.Close()
+			exe.Stdin, exe.Stdout, exe.Stderr = tty, tty, tty
+		}
+	}
+	if err := exe.Run(); err != nil {
+		return fmt.Errorf("editor %q: %v", exe.Args, err)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"vim", []string{"vim"}},
+		{"  code  --wait ", []string{"code", "--wait"}},
+		{`"/opt/My Editor/edit" -n`, []string{"/opt/My Editor/edit", "-n"}},
+		{`emacs -nw --eval '(setq x "a b")'`, []string{"emacs", "-nw", "--eval", `(setq x "a b")`}},
+		{`/opt/My\ Editor/edit`, []string{"/opt/My Editor/edit"}},
+		{`a "" b`, []string{"a", "", "b"}},
+		{`"a \"quoted\" b"`, []string{`a "quoted" b`}},
+	}
+	for _, tt := range tests {
+		got, err := splitArgs(tt.s)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitArgs(%q) = %q, %v, want %q", tt.s, got, err, tt.want)
+		}
+	}
+	if _, err := splitArgs(`vim "unclosed`); err == nil {
+		t.Errorf("unclosed quote: no error")
+	}
+}
+
+func TestGetCmd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("looks up unix commands")
+	}
+	t.Setenv("VISUAL", "true --visual")
+	t.Setenv("EDITOR", "false")
+	cmd, err := getCmd("my card.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cmd) != 3 || cmd[1] != "--visual" || cmd[2] != "my card.txt" {
+		t.Errorf("$VISUAL: cmd = %q", cmd)
+	}
+
+	defer func() { editorCommand = "" }()
+	editorCommand = "false -x"
+	if cmd, _ := getCmd("card.txt"); len(cmd) != 3 || cmd[1] != "-x" {
+		t.Errorf("-editor: cmd = %q", cmd)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+)
+
+// Code fences are highlighted with inline styles when the cards are rendered
+// to HTML, so they look the same in every Anki client without a stylesheet:
+/*
+```python
+# comment		->	<pre style="background:...">
+x = "a"			->	<span style="color:...">...
+```
+*/
+// The lexer only knows comments, strings, numbers and keywords, which is
+// enough to read the code of a flashcard.
+
+type codeTheme struct {
+	background, foreground        string
+	keyword, str, comment, number string
+}
+
+var codeThemes = map[string]codeTheme{
+	"github":          {background: "#f6f8fa", foreground: "#24292e", keyword: "#d73a49", str: "#032f62", comment: "#6a737d", number: "#005cc5"},
+	"monokai":         {background: "#272822", foreground: "#f8f8f2", keyword: "#f92672", str: "#e6db74", comment: "#75715e", number: "#ae81ff"},
+	"solarized-light": {background: "#fdf6e3", foreground: "#657b83", keyword: "#859900", str: "#2aa198", comment: "#93a1a1", number: "#d33682"},
+}
+
+// codeThemeName is the theme used for code fences, "none" turns highlighting
+// off.
+var codeThemeName = "github"
+
+// codeThemeNames returns the names of all themes for the usage message.
+func codeThemeNames() string {
+	var names []string
+	for name := range codeThemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(append(names, "none"), ", ")
+}
+
+type codeLang struct {
+	keywords     map[string]bool
+	lineComments []string
+	blockComment [2]string
+	quotes       string
+	// multiline quotes may span lines, like Go's raw strings.
+	multiline string
+	// ignoreCase is set for languages like SQL.
+	ignoreCase bool
+}
+
+func words(s string) map[string]bool {
+	m := map[string]bool{}
+	for _, w := range strings.Fields(s) {
+		m[w] = true
+	}
+	return m
+}
+
+var (
+	cLike = codeLang{
+		keywords:     words("auto break case char const continue default do double else enum extern float for goto if inline int long register return short signed sizeof static struct switch typedef union unsigned void volatile while bool true false NULL nullptr class public private protected virtual template typename namespace using new delete this throw try catch final abstract extends implements import package interface boolean byte super instanceof null var"),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+	}
+	codeLangs = map[string]*codeLang{
+		"go": {
+			keywords:     words("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var nil true false iota bool byte error int int8 int16 int32 int64 uint uint8 uint16 uint32 uint64 uintptr float32 float64 string rune any make new len cap append copy delete panic recover"),
+			lineComments: []string{"//"},
+			blockComment: [2]string{"/*", "*/"},
+			quotes:       "\"'`",
+			multiline:    "`",
+		},
+		"python": {
+			keywords:     words("and as assert async await break class continue def del elif else except False finally for from global if import in is lambda None nonlocal not or pass raise return True try while with yield self print len range"),
+			lineComments: []string{"#"},
+			quotes:       `"'`,
+		},
+		"javascript": {
+			keywords:     words("async await break case catch class const continue debugger default delete do else export extends false finally for function if import in instanceof let new null return super switch this throw true try typeof undefined var void while with yield interface type enum implements readonly"),
+			lineComments: []string{"//"},
+			blockComment: [2]string{"/*", "*/"},
+			quotes:       "\"'`",
+			multiline:    "`",
+		},
+		"rust": {
+			keywords:     words("as async await break const continue crate dyn else enum extern false fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait true type unsafe use where while i8 i16 i32 i64 u8 u16 u32 u64 usize isize f32 f64 bool str String Vec Option Some None Result Ok Err"),
+			lineComments: []string{"//"},
+			blockComment: [2]string{"/*", "*/"},
+			quotes:       `"`,
+		},
+		"shell": {
+			keywords:     words("if then else elif fi for while until do done case esac in function return export local echo exit set unset"),
+			lineComments: []string{"#"},
+			quotes:       `"'`,
+		},
+		"sql": {
+			keywords:     words("select from where and or not insert into values update set delete create table drop alter index primary key foreign references join left right inner outer on group by order having limit as distinct null is in like between union all case when then else end count sum avg min max"),
+			lineComments: []string{"--"},
+			blockComment: [2]string{"/*", "*/"},
+			quotes:       `'"`,
+			ignoreCase:   true,
+		},
+		"c": &cLike,
+	}
+	codeLangAliases = map[string]string{
+		"golang": "go", "py": "python", "python3": "python",
+		"js": "javascript", "typescript": "javascript", "ts": "javascript", "jsx": "javascript", "tsx": "javascript",
+		"rs": "rust", "sh": "shell", "bash": "shell", "zsh": "shell",
+		"cpp": "c", "c++": "c", "java": "c", "c#": "c", "csharp": "c", "kotlin": "c",
+	}
+)
+
+// lookupLang returns the lexer for the info string of a code fence, which
+// Notion writes capitalised, e.g. "Python".
+func lookupLang(name string) *codeLang {
+	name = strings.ToLower(name)
+	if alias, ok := codeLangAliases[name]; ok {
+		name = alias
+	}
+	return codeLangs[name]
+}
+
+// highlightCode renders code as a <pre> block with inline styles. Unknown
+// languages are only coloured with the theme's fore- and background.
+func highlightCode(code, lang string, theme codeTheme) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<pre style="background:%s;color:%s;padding:8px;border-radius:4px;text-align:left;overflow-x:auto"><code>`, theme.background, theme.foreground)
+	l := lookupLang(lang)
+	if l == nil {
+		b.WriteString(html.EscapeString(code))
+		b.WriteString("</code></pre>")
+		return b.String()
+	}
+	span := func(color, text string) {
+		fmt.Fprintf(&b, `<span style="color:%s">%s</span>`, color, html.EscapeString(text))
+	}
+	for i := 0; i < len(code); {
+		rest := code[i:]
+		if n := l.comment(rest); n > 0 {
+			span(theme.comment, rest[:n])
+			i += n
+			continue
+		}
+		c := code[i]
+		if strings.IndexByte(l.quotes, c) >= 0 {
+			n := l.str(rest)
+			span(theme.str, rest[:n])
+			i += n
+			continue
+		}
+		if isWordByte(c) || c == '_' {
+			j := i
+			for j < len(code) && (isWordByte(code[j]) || code[j] == '_') {
+				j++
+			}
+			word := code[i:j]
+			switch {
+			case c >= '0' && c <= '9':
+				// also takes the fraction and the exponent.
+				for j < len(code) && (isWordByte(code[j]) || code[j] == '.') {
+					j++
+				}
+				span(theme.number, code[i:j])
+			case l.keywords[word] || l.ignoreCase && l.keywords[strings.ToLower(word)]:
+				span(theme.keyword, word)
+			default:
+				b.WriteString(html.EscapeString(word))
+			}
+			i = j
+			continue
+		}
+		b.WriteString(html.EscapeString(code[i : i+1]))
+		i++
+	}
+	b.WriteString("</code></pre>")
+	return b.String()
+}
+
+// comment returns the length of the comment s starts with.
+func (l *codeLang) comment(s string) int {
+	for _, lc := range l.lineComments {
+		if strings.HasPrefix(s, lc) {
+			if end := strings.IndexByte(s, '\n'); end >= 0 {
+				return end
+			}
+			return len(s)
+		}
+	}
+	if open := l.blockComment[0]; open != "" && strings.HasPrefix(s, open) {
+		if end := strings.Index(s[len(open):], l.blockComment[1]); end >= 0 {
+			return len(open) + end + len(l.blockComment[1])
+		}
+		return len(s)
+	}
+	return 0
+}
+
+// str returns the length of the string literal s starts with. Unterminated
+// strings end with the line.
+func (l *codeLang) str(s string) int {
+	q := s[0]
+	multiline := strings.IndexByte(l.multiline, q) >= 0
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && !multiline:
+			i++
+		case s[i] == q:
+			return i + 1
+		case s[i] == '\n' && !multiline:
+			return i
+		}
+	}
+	return len(s)
+}
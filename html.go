@@ -0,0 +1,441 @@
+package main
+
+import (
+	"html"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Notion's HTML export keeps what the Markdown export throws away: colours,
+// callouts and equations. Toggles are <details> elements:
+/*
+<ul class="toggle">
+	<li>
+		<details open="">
+			<summary>front</summary>
+			<p>back</p>
+		</details>
+	</li>
+</ul>
+*/
+// parseNotionHTML turns such a page into the same nodes parseMarkdown returns
+// for the Markdown export, with the front and back of toggles kept as HTML.
+
+// htmlNode is an element or, if tag is empty, a text node of an HTML document.
+type htmlNode struct {
+	tag      string
+	attrs    []htmlAttr
+	text     string // raw, still escaped, text of a text node.
+	children []*htmlNode
+}
+
+type htmlAttr struct {
+	key, val string
+}
+
+func (n *htmlNode) attr(key string) string {
+	for _, a := range n.attrs {
+		if a.key == key {
+			return a.val
+		}
+	}
+	return ""
+}
+
+func (n *htmlNode) hasClass(class string) bool {
+	for _, c := range strings.Fields(n.attr("class")) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	voidElements = map[string]bool{
+		"area": true, "base": true, "br": true, "col": true, "embed": true,
+		"hr": true, "img": true, "input": true, "link": true, "meta": true,
+		"source": true, "track": true, "wbr": true,
+	}
+	rawTextElements = map[string]bool{"script": true, "style": true, "title": true, "textarea": true}
+)
+
+// parseHTML builds the element tree of s. It is forgiving, but does not
+// implement the HTML5 parsing algorithm: Notion writes well-formed documents.
+func parseHTML(s string) *htmlNode {
+	root := &htmlNode{tag: "#root"}
+	stack := []*htmlNode{root}
+	top := func() *htmlNode { return stack[len(stack)-1] }
+
+	for i := 0; i < len(s); {
+		switch {
+		case strings.HasPrefix(s[i:], "<!--"):
+			end := strings.Index(s[i+4:], "-->")
+			if end == -1 {
+				return root
+			}
+			i += 4 + end + 3
+		case strings.HasPrefix(s[i:], "<!") || strings.HasPrefix(s[i:], "<?"):
+			end := strings.IndexByte(s[i:], '>')
+			if end == -1 {
+				return root
+			}
+			i += end + 1
+		case strings.HasPrefix(s[i:], "</"):
+			end := strings.IndexByte(s[i:], '>')
+			if end == -1 {
+				return root
+			}
+			name := strings.ToLower(strings.TrimSpace(s[i+2 : i+end]))
+			for k := len(stack) - 1; k > 0; k-- {
+				if stack[k].tag == name {
+					stack = stack[:k]
+					break
+				}
+			}
+			i += end + 1
+		case s[i] == '<' && i+1 < len(s) && isASCIILetter(s[i+1]):
+			n, selfClosing, next := parseStartTag(s, i)
+			top().children = append(top().children, n)
+			i = next
+			if rawTextElements[n.tag] {
+				end := strings.Index(strings.ToLower(s[i:]), "</"+n.tag)
+				if end == -1 {
+					end = len(s) - i
+				}
+				n.children = append(n.children, &htmlNode{text: s[i : i+end]})
+				i += end
+				continue
+			}
+			if !selfClosing && !voidElements[n.tag] {
+				stack = append(stack, n)
+			}
+		default:
+			end := strings.IndexByte(s[i+1:], '<')
+			if end == -1 {
+				end = len(s) - i - 1
+			}
+			top().children = append(top().children, &htmlNode{text: s[i : i+1+end]})
+			i += 1 + end
+		}
+	}
+	return root
+}
+
+// parseStartTag parses the tag starting at s[i] and returns the index after it.
+func parseStartTag(s string, i int) (n *htmlNode, selfClosing bool, next int) {
+	i++ // <
+	start := i
+	for i < len(s) && !isHTMLSpace(s[i]) && s[i] != '>' && s[i] != '/' {
+		i++
+	}
+	n = &htmlNode{tag: strings.ToLower(s[start:i])}
+
+	for i < len(s) {
+		for i < len(s) && isHTMLSpace(s[i]) {
+			i++
+		}
+		if i >= len(s) {
+			break
+		}
+		if s[i] == '>' {
+			return n, selfClosing, i + 1
+		}
+		if s[i] == '/' {
+			selfClosing = true
+			i++
+			continue
+		}
+		start := i
+		for i < len(s) && !isHTMLSpace(s[i]) && s[i] != '=' && s[i] != '>' && s[i] != '/' {
+			i++
+		}
+		a := htmlAttr{key: strings.ToLower(s[start:i])}
+		if i < len(s) && s[i] == '=' {
+			i++
+			if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+				q := s[i]
+				end := strings.IndexByte(s[i+1:], q)
+				if end == -1 {
+					end = len(s) - i - 1
+				}
+				a.val = html.UnescapeString(s[i+1 : i+1+end])
+				i += end + 2
+			} else {
+				start := i
+				for i < len(s) && !isHTMLSpace(s[i]) && s[i] != '>' {
+					i++
+				}
+				a.val = html.UnescapeString(s[start:i])
+			}
+		}
+		if a.key != "" {
+			n.attrs = append(n.attrs, a)
+		}
+	}
+	return n, selfClosing, len(s)
+}
+
+func isASCIILetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// textContent returns the unescaped text of n and its descendants.
+func (n *htmlNode) textContent() string {
+	if n.tag == "" {
+		return html.UnescapeString(n.text)
+	}
+	var sb strings.Builder
+	for _, c := range n.children {
+		sb.WriteString(c.textContent())
+	}
+	return sb.String()
+}
+
+func (n *htmlNode) find(match func(*htmlNode) bool) *htmlNode {
+	if match(n) {
+		return n
+	}
+	for _, c := range n.children {
+		if f := c.find(match); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// isHTMLPage reports whether fp is a page of the HTML export.
+func isHTMLPage(fp string) bool {
+	ext := strings.ToLower(path.Ext(fp))
+	return ext == ".html" || ext == ".htm"
+}
+
+// parseNotionHTML returns the headings and toggles of a Notion HTML export
+// in document order. The page title comes first, like in the Markdown export.
+func parseNotionHTML(raw []byte) []*node {
+	doc := parseHTML(string(raw))
+	var nodes []*node
+	if title := doc.find(func(n *htmlNode) bool { return n.hasClass("page-title") }); title != nil {
+		nodes = append(nodes, &node{kind: nodeHeading, level: 1, text: []byte(strings.TrimSpace(title.textContent()))})
+	}
+	body := doc.find(func(n *htmlNode) bool { return n.hasClass("page-body") })
+	if body == nil {
+		if body = doc.find(func(n *htmlNode) bool { return n.tag == "body" }); body == nil {
+			body = doc
+		}
+	}
+	return append(nodes, htmlBlocks(body)...)
+}
+
+func htmlBlocks(n *htmlNode) []*node {
+	var nodes []*node
+	for _, c := range n.children {
+		switch c.tag {
+		case "h1", "h2", "h3", "h4", "h5", "h6":
+			nodes = append(nodes, &node{
+				kind:  nodeHeading,
+				level: int(c.tag[1] - '0'),
+				text:  []byte(strings.TrimSpace(c.textContent())),
+			})
+		case "details":
+			nodes = append(nodes, htmlToggle(c))
+		case "", "style", "script":
+		default:
+			nodes = append(nodes, htmlBlocks(c)...)
+		}
+	}
+	return nodes
+}
+
+// htmlToggle turns a <details> element into a toggle node. Its children are
+// the nested toggles and a paragraph for every other block of the body.
+func htmlToggle(d *htmlNode) *node {
+	t := &node{kind: nodeListItem, marker: '-', spaced: true}
+	var body []string
+	for _, c := range d.children {
+		if c.tag == "summary" {
+			t.text = []byte(strings.TrimSpace(renderHTMLChildren(c)))
+			continue
+		}
+		if c.tag == "" && strings.TrimSpace(c.text) == "" {
+			continue
+		}
+		rendered := strings.TrimSpace(renderHTML(c))
+		body = append(body, rendered)
+		if c.tag != "" && containsOnlyToggles(c) {
+			t.children = append(t.children, htmlBlocks(&htmlNode{children: []*htmlNode{c}})...)
+			continue
+		}
+		t.children = append(t.children, &node{kind: nodeParagraph, text: []byte(rendered), src: []byte(rendered + "\n")})
+	}
+	t.src = []byte(strings.TrimSpace(renderHTML(d)) + "\n")
+	if len(body) == 0 {
+		return t
+	}
+	t.body = []byte(strings.Join(body, "\n") + "\n")
+	return t
+}
+
+// containsOnlyToggles reports whether n is nothing but a wrapper of toggles,
+// like Notion's <ul class="toggle">.
+func containsOnlyToggles(n *htmlNode) bool {
+	if n.tag == "details" {
+		return true
+	}
+	if n.tag == "" {
+		return strings.TrimSpace(n.text) == ""
+	}
+	if n.tag != "ul" && n.tag != "li" {
+		return false
+	}
+	for _, c := range n.children {
+		if !containsOnlyToggles(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// notionColors are the colours of Notion's stylesheet, which Anki does not
+// have. They are inlined into the elements using them.
+var notionColors = map[string]string{
+	"gray":   "rgba(120, 119, 116, 1)",
+	"brown":  "rgba(159, 107, 83, 1)",
+	"orange": "rgba(217, 115, 13, 1)",
+	"yellow": "rgba(203, 145, 47, 1)",
+	"teal":   "rgba(68, 131, 97, 1)",
+	"blue":   "rgba(51, 126, 169, 1)",
+	"purple": "rgba(144, 101, 176, 1)",
+	"pink":   "rgba(193, 76, 138, 1)",
+	"red":    "rgba(212, 76, 71, 1)",
+
+	"gray_background":   "rgba(241, 241, 239, 1)",
+	"brown_background":  "rgba(244, 238, 238, 1)",
+	"orange_background": "rgba(251, 236, 221, 1)",
+	"yellow_background": "rgba(251, 243, 219, 1)",
+	"teal_background":   "rgba(237, 243, 236, 1)",
+	"blue_background":   "rgba(231, 243, 248, 1)",
+	"purple_background": "rgba(244, 240, 247, 0.8)",
+	"pink_background":   "rgba(249, 238, 243, 0.8)",
+	"red_background":    "rgba(253, 235, 236, 1)",
+}
+
+// colorStyle returns the inline style for Notion's colour classes of n.
+func colorStyle(n *htmlNode) string {
+	var styles []string
+	for _, c := range strings.Fields(n.attr("class")) {
+		name := strings.TrimPrefix(strings.TrimPrefix(c, "highlight-"), "block-color-")
+		color, ok := notionColors[name]
+		if !ok || name == c {
+			continue
+		}
+		if strings.HasSuffix(name, "_background") {
+			styles = append(styles, "background-color:"+color)
+		} else {
+			styles = append(styles, "color:"+color)
+		}
+	}
+	return strings.Join(styles, ";")
+}
+
+// renderHTML serialises n for an Anki field: equations become MathJax, the
+// colours are inlined and images reference the media files by the names
+// md2anki stores them under.
+func renderHTML(n *htmlNode) string {
+	var sb strings.Builder
+	writeHTML(&sb, n)
+	return sb.String()
+}
+
+func renderHTMLChildren(n *htmlNode) string {
+	var sb strings.Builder
+	for _, c := range n.children {
+		writeHTML(&sb, c)
+	}
+	return sb.String()
+}
+
+func writeHTML(sb *strings.Builder, n *htmlNode) {
+	if n.tag == "" {
+		sb.WriteString(n.text)
+		return
+	}
+	switch {
+	case n.tag == "style" || n.tag == "script":
+		return
+	case n.tag == "figure" && n.hasClass("equation"):
+		if tex := texOf(n); tex != "" {
+			sb.WriteString(`\[` + html.EscapeString(tex) + `\]`)
+			return
+		}
+	case n.hasClass("notion-text-equation-token"):
+		if tex := texOf(n); tex != "" {
+			sb.WriteString(`\(` + html.EscapeString(tex) + `\)`)
+			return
+		}
+	}
+
+	sb.WriteString("<" + n.tag)
+	style := n.attr("style")
+	if cs := colorStyle(n); cs != "" {
+		if style != "" && !strings.HasSuffix(style, ";") {
+			style += ";"
+		}
+		style += cs
+	}
+	for _, a := range n.attrs {
+		val := a.val
+		switch a.key {
+		case "id":
+			continue
+		case "style":
+			val = style
+		case "src", "href":
+			val = mediaRef(val)
+		}
+		sb.WriteString(" " + a.key + `="` + html.EscapeString(val) + `"`)
+	}
+	if style != "" && n.attr("style") == "" {
+		sb.WriteString(` style="` + html.EscapeString(style) + `"`)
+	}
+	sb.WriteString(">")
+	if voidElements[n.tag] {
+		return
+	}
+	for _, c := range n.children {
+		writeHTML(sb, c)
+	}
+	sb.WriteString("</" + n.tag + ">")
+}
+
+// texOf returns the TeX source KaTeX keeps in an annotation of the rendered
+// equation n.
+func texOf(n *htmlNode) string {
+	a := n.find(func(n *htmlNode) bool {
+		return n.tag == "annotation" && n.attr("encoding") == "application/x-tex"
+	})
+	if a == nil {
+		return ""
+	}
+	return strings.TrimSpace(a.textContent())
+}
+
+// mediaRef rewrites a relative link into the exported media folder, e.g.
+// "Page%20abc/image.png", to the name of the media file in Anki,
+// "Page abc_image.png", see mediaName.
+func mediaRef(ref string) string {
+	u, err := url.Parse(ref)
+	if err != nil || u.Scheme != "" || u.Host != "" || strings.HasPrefix(u.Path, "/") {
+		return ref
+	}
+	dir, name := path.Split(u.Path)
+	if dir == "" || strings.HasSuffix(strings.ToLower(name), ".html") {
+		return ref
+	}
+	return mediaName(strings.TrimSuffix(dir, "/"), name)
+}
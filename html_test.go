@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const notionHTMLPage = `<html><head><meta http-equiv="Content-Type" content="text/html; charset=utf-8"/><title>Page</title><style>
+/* h1 { } */
+</style></head><body><article id="1" class="page sans"><header><h1 class="page-title">Page</h1></header><div class="page-body">
+<h1 id="2" class="">Chapter &amp; Verse</h1>
+<ul id="3" class="toggle"><li><details open=""><summary>What is <strong>entropy</strong>?</summary><p id="4" class="">The average <mark class="highlight-red">information</mark>:</p><figure id="5" class="equation"><style>@import url('katex.min.css')</style><div class="equation-container"><span class="katex-display"><span class="katex"><span class="katex-mathml"><math><semantics><annotation encoding="application/x-tex">H(X) = E(I(X))</annotation></semantics></math></span></span></span></div></figure><figure id="6" class="image"><a href="Page%20abc/Untitled%201.png"><img style="width:100px" src="Page%20abc/Untitled%201.png"/></a></figure><ul id="7" class="toggle"><li><details open=""><summary>Nested</summary><p id="8" class="">inner</p></details></li></ul></details></li></ul>
+<h2 id="9" class="">Section</h2>
+<pre id="10" class="code"><code># not a heading</code></pre>
+<ul id="11" class="toggle"><li><details open=""><summary>Empty</summary></details></li></ul>
+</div></article></body></html>`
+
+func TestParseNotionHTML(t *testing.T) {
+	cs := collectPageCards("Page abc.html", notionHTMLPage)
+	if len(cs) != 1 {
+		t.Fatalf("got %d cards, want 1: %v", len(cs), cs)
+	}
+	c := cs[0]
+	if !c.html {
+		t.Error("card is not marked as HTML")
+	}
+	if got, want := string(c.front), "What is <strong>entropy</strong>?"; got != want {
+		t.Errorf("front = %q, want %q", got, want)
+	}
+	if got := string(c.tags[0]); got != "Chapter_&_Verse" {
+		t.Errorf("tag = %q", got)
+	}
+	for _, want := range []string{
+		`<mark class="highlight-red" style="color:rgba(212, 76, 71, 1)">information</mark>`,
+		`\[H(X) = E(I(X))\]`,
+		`<img style="width:100px" src="Page abc_Untitled 1.png">`,
+		`<summary>Nested</summary>`,
+	} {
+		if !strings.Contains(string(c.back), want) {
+			t.Errorf("back does not contain %q:\n%s", want, c.back)
+		}
+	}
+	if strings.Contains(string(c.back), "id=") || strings.Contains(string(c.back), "katex") {
+		t.Errorf("back contains export clutter:\n%s", c.back)
+	}
+}
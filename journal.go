@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// The session journal records every toggle reviewed in the editor as soon as
+// the editor is closed, one JSON object per line:
+/*
+{"guid":"Xy3...","cards":[{"front":"...","back":"...","tags":["a"],"guid":"Xy3..."}]}
+{"guid":"Ab1...","skip":true}
+*/
+// If md2anki is interrupted, the journal is kept and a run with -resume takes
+// the cards of the reviewed toggles from it instead of opening the editor
+// again. After a successful run it is deleted.
+
+// resumeSession continues the session of the journal left by an interrupted
+// run.
+var resumeSession bool
+
+type journalEntry struct {
+	// GUID identifies the reviewed toggle.
+	GUID  string        `json:"guid"`
+	Skip  bool          `json:"skip,omitempty"`
+	Cards []journalCard `json:"cards,omitempty"`
+}
+
+type journalCard struct {
+	Front string   `json:"front"`
+	Back  string   `json:"back"`
+	Tags  []string `json:"tags,omitempty"`
+	GUID  string   `json:"guid"`
+	Deck  string   `json:"deck"`
+	Cloze bool     `json:"cloze,omitempty"`
+}
+
+// journal is the journal of a session. A nil journal records nothing.
+type journal struct {
+	fp   string
+	f    *os.File
+	done map[string]journalEntry
+	// cut is set if the last line was cut off.
+	cut bool
+}
+
+// journalFilename returns the journal of the session writing the output for
+// fp.
+func journalFilename(fp string) string {
+	return pageTitleToDeckName(fp) + ".session.jsonl"
+}
+
+// openJournal opens the journal fp. With resume the entries of an interrupted
+// session are loaded, without it an existing journal is an error, so the
+// edits in it are not lost by accident.
+func openJournal(fp string, resume bool) (*journal, error) {
+	j := &journal{fp: fp, done: map[string]journalEntry{}}
+	if exists(fp) && !resume {
+		return nil, fmt.Errorf("%q holds the edits of an interrupted run, continue it with -resume or delete it", fp)
+	}
+	if resume {
+		if err := j.load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(fp, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	j.f = f
+	if j.cut {
+		// start the next entry on a line of its own.
+		if _, err := f.Write([]byte{'\n'}); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+// load reads the entries of the journal. A line cut off by a crash is left
+// out, that toggle is reviewed again.
+func (j *journal) load() error {
+	raw, err := os.ReadFile(j.fp)
+	if err != nil {
+		return err
+	}
+	for n, line := range bytes.Split(raw, []byte{'\n'}) {
+		if len(line) == 0 {
+			continue
+		}
+		var e journalEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			log.Printf("%s:%d: %v\n", j.fp, n+1, err)
+			continue
+		}
+		j.done[e.GUID] = e
+	}
+	j.cut = len(raw) > 0 && raw[len(raw)-1] != '\n'
+	return nil
+}
+
+// reviewed returns the cards the toggle card was turned into in the editor.
+// ok is false if it has not been reviewed yet.
+func (j *journal) reviewed(card card2) (cs []card2, ok bool) {
+	if j == nil {
+		return nil, false
+	}
+	e, ok := j.done[card.guid]
+	if !ok {
+		return nil, false
+	}
+	for _, jc := range e.Cards {
+		c := card
+		c.front, c.back, c.guid = []byte(jc.Front), []byte(jc.Back), jc.GUID
+		c.deck, c.cloze = jc.Deck, jc.Cloze
+		c.tags = nil
+		for _, tag := range jc.Tags {
+			c.tags = append(c.tags, []byte(tag))
+		}
+		cs = append(cs, c)
+	}
+	return cs, true
+}
+
+// record adds the cards the toggle with guid was turned into, none if it was
+// skipped, and syncs the journal to disk.
+func (j *journal) record(guid string, cs []card2) error {
+	if j == nil {
+		return nil
+	}
+	e := journalEntry{GUID: guid, Skip: len(cs) == 0}
+	for _, c := range cs {
+		jc := journalCard{Front: string(c.front), Back: string(c.back), GUID: c.guid, Deck: c.deck, Cloze: c.cloze}
+		for _, tag := range c.tags {
+			jc.Tags = append(jc.Tags, string(tag))
+		}
+		e.Cards = append(e.Cards, jc)
+	}
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(raw, '\n')); err != nil {
+		return err
+	}
+	j.done[guid] = e
+	return j.f.Sync()
+}
+
+// close closes the journal. A finished session has no use for it anymore, so
+// it is removed, like one without any reviewed toggles.
+func (j *journal) close(finished bool) error {
+	err := j.f.Close()
+	if finished || len(j.done) == 0 {
+		return os.Remove(j.fp)
+	}
+	return err
+}
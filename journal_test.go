@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestJournalResume(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses $EDITOR")
+	}
+	chdir(t, t.TempDir())
+	// the editor fails, so all cards have to come from the journal.
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", "false")
+	fp := "Page abc.md"
+	if err := os.WriteFile(fp, []byte("# Page\n\n- one\n\n\tback\n\n- two\n\n\tback\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := openJournal(journalFilename(fp), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	one := card2{front: []byte("edited one"), back: []byte("edited back"), tags: [][]byte{[]byte("t")}, guid: noteGUID("abc", []byte("one"), 0), deck: "Page::Edited"}
+	if err := j.record(one.guid, []card2{one}); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.record(noteGUID("abc", []byte("two"), 0), nil); err != nil {
+		t.Fatal(err)
+	}
+	// a crash in the middle of writing an entry.
+	j.f.WriteString(`{"guid":"cut`)
+	if err := j.close(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Process(context.Background(), fp, outputText, reviewAll); exitCode(err) != exitReview {
+		t.Errorf("without -resume: err = %v, want a review error", err)
+	}
+
+	defer func() { resumeSession = false }()
+	resumeSession = true
+	if err := Process(context.Background(), fp, outputText, reviewAll); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(MdToAnkiFilename(fp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := string(raw); !strings.Contains(out, "edited one,edited back,t,") || !strings.Contains(out, ",Page::Edited\n") || strings.Contains(out, "two") {
+		t.Errorf("output = %q, want only the edited card", out)
+	}
+	if _, err := os.Stat(journalFilename(fp)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the journal of a finished session is kept")
+	}
+}
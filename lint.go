@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+)
+
+// review selects which cards Prompter opens in the editor.
+type review int
+
+const (
+	// reviewAll opens every card in the editor.
+	reviewAll review = iota
+	// reviewNone passes all cards straight to the serialiser.
+	reviewNone
+	// reviewFlagged only opens cards failing a lint check.
+	reviewFlagged
+)
+
+// edit reports whether a card with the lint problems should be opened in the
+// editor.
+func (r review) edit(problems []string) bool {
+	switch r {
+	case reviewNone:
+		return false
+	case reviewFlagged:
+		return len(problems) > 0
+	default:
+		return true
+	}
+}
+
+// maxFrontLen is the length in characters from which on a front is most
+// likely not a question, but a paragraph that ended up in a toggle.
+const maxFrontLen = 300
+
+// lintCard returns the problems of a card which should be looked at before it
+// is added to Anki.
+func lintCard(c card2) []string {
+	var problems []string
+	front, back := bytes.TrimSpace(c.front), bytes.TrimSpace(c.back)
+	if len(front) == 0 {
+		problems = append(problems, "the front is empty")
+	}
+	if len(back) == 0 && !isCloze(c) {
+		problems = append(problems, "the back is empty")
+	}
+	if n := utf8.RuneCount(front); n > maxFrontLen {
+		problems = append(problems, fmt.Sprintf("the front is %d characters long", n))
+	}
+	for _, side := range []struct {
+		name string
+		text []byte
+	}{{"front", c.front}, {"back", c.back}} {
+		if bytes.Count(side.text, []byte("```"))%2 != 0 {
+			problems = append(problems, "the "+side.name+" has an unclosed code block")
+		}
+		if unbalancedDollars(side.text) {
+			problems = append(problems, "the "+side.name+" has an unclosed $ math expression")
+		}
+		if bytes.Count(side.text, []byte("{{")) != bytes.Count(side.text, []byte("}}")) {
+			problems = append(problems, "the "+side.name+" has unbalanced {{ }}")
+		}
+		if bytes.Contains(side.text, []byte("![")) {
+			problems = append(problems, "the "+side.name+" references an image, which is not converted (use -media)")
+		}
+	}
+	return problems
+}
+
+// unbalancedDollars reports whether text has an odd number of unescaped $,
+// which leaves a math expression open.
+func unbalancedDollars(text []byte) bool {
+	var n int
+	for i, c := range text {
+		if c == '$' && (i == 0 || text[i-1] != '\\') {
+			n++
+		}
+	}
+	return n%2 != 0
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestLintCard(t *testing.T) {
+	tests := []struct {
+		name string
+		card card2
+		n    int
+	}{
+		{"fine", card2{front: []byte("front"), back: []byte("$x$ and $$y$$\n")}, 0},
+		{"empty back", card2{front: []byte("front")}, 1},
+		{"open math", card2{front: []byte("front $x"), back: []byte("back")}, 1},
+		{"open code block", card2{front: []byte("front"), back: []byte("```go\nx := 1\n")}, 1},
+		{"cloze without back", card2{front: []byte("{{c1::front}}")}, 0},
+	}
+	for _, tt := range tests {
+		if got := lintCard(tt.card); len(got) != tt.n {
+			t.Errorf("%s: got problems %q, want %d", tt.name, got, tt.n)
+		}
+	}
+}
+
+func TestPrompterNoEdit(t *testing.T) {
+	cards := make(chan card2)
+	edited := make(chan card2)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go Prompter(context.Background(), cards, edited, reviewNone, nil, nil, &wg, toMathJax)
+
+	go func() {
+		cards <- card2{front: []byte("$x$"), back: []byte("back")}
+		cards <- card2{front: []byte("empty")}
+		close(cards)
+	}()
+	var got []card2
+	for c := range edited {
+		got = append(got, c)
+	}
+	wg.Wait()
+
+	if len(got) != 2 {
+		t.Fatalf("got %d cards, want 2", len(got))
+	}
+	if string(got[0].front) != `\(x\)` {
+		t.Errorf("mutations were not applied: %q", got[0].front)
+	}
+}
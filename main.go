@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -13,9 +15,9 @@ import (
 )
 
 // Linux, Darwin:
-// 		go build -o md2anki main.go toggle.go
+// 		go build -o md2anki main.go toggle.go markdown.go apkg.go sqlite.go ankiconnect.go cloze.go lint.go workspace.go html.go nested.go render.go highlight.go journal.go prompt.go editor.go review.go tui.go web.go watch.go state.go config.go tags.go
 // Windows:
-//		go build -o md2anki.exe main.go toggle.go
+//		go build -o md2anki.exe main.go toggle.go markdown.go apkg.go sqlite.go ankiconnect.go cloze.go lint.go workspace.go html.go nested.go render.go highlight.go journal.go prompt.go editor.go review.go tui.go web.go watch.go state.go config.go tags.go
 
 var NAME string
 var CallPrefix string
@@ -38,41 +40,205 @@ func main() {
 	var mutations []options
 	FlagToMathJax := flag.Bool("math", false, "convert to mathjax")
 	FlagIncludeMedia := flag.Bool("media", false, "include media")
+	FlagToHTML := flag.Bool("html", false, "render the Markdown of the cards to HTML")
+	flag.StringVar(&codeThemeName, "code-theme", codeThemeName, "colour theme of code blocks rendered by -html: "+codeThemeNames())
+	FlagApkg := flag.Bool("apkg", false, "write an .apkg package including the media files")
+	FlagAnkiConnect := flag.Bool("anki-connect", false, "add the cards to the running Anki through AnkiConnect")
+	flag.StringVar(&clozeMarker, "cloze-marker", clozeMarker, "turn bold text of toggles starting with this marker, e.g. cloze:, into cloze deletions")
+	flag.Var(&nestedToggles, "nested", "what to do with toggles inside of toggles: keep, cards, details or drop")
+	flag.StringVar(&ankiConnectURL, "anki-connect-url", ankiConnectURL, "address of AnkiConnect")
+	flag.StringVar(&ankiConnectModel, "note-type", ankiConnectModel, "note type of the cards added through AnkiConnect")
+	flag.Var(&ankiConnectFields, "fields", "fields of the note type the front and back go to, separated by a comma")
+	flag.StringVar(&ankiConnectClozeModel, "cloze-note-type", ankiConnectClozeModel, "note type of the cloze cards added through AnkiConnect")
+	flag.Var(&ankiConnectClozeFields, "cloze-fields", "fields of the cloze note type the text and back extra go to, separated by a comma")
+	flag.Var(&tagStrategy, "tags", "how to tag the cards: headings, hierarchical or none")
+	flag.IntVar(&headingDepth, "heading-depth", headingDepth, "deepest heading level used as a tag, 1 to 6")
+	flag.IntVar(&subdeckDepth, "subdecks", 0, "deepest heading level which becomes a subdeck instead of a tag, 0 for none")
+	flag.StringVar(&tagSpace, "tag-space", tagSpace, "replacement for the spaces of headings in tags")
+	flag.Var(&tagChars, "tag-chars", "characters of headings kept in tags: all, letters or ascii")
+	flag.Var(&tagCase, "tag-case", "case of tags: keep, lower, kebab or snake")
+	tagMapFile := flag.String("tag-map", "", "file renaming headings to tags, one \"heading = tag\" per line")
+	flag.Var(&ignoredHeadings, "ignore-headings", "headings which are no tags, separated by a comma")
+	flag.Var(&staticTags, "add-tags", "tags added to every card, separated by a comma")
+	flag.StringVar(&ankiProfile, "anki-profile", "", "Anki profile the media files are moved to")
+	flag.StringVar(&ankiMediaDir, "media-dir", "", "collection.media folder the media files are moved to, instead of the one of the Anki profile")
+	flag.StringVar(&profileName, "profile", "", "profile of the config file to apply")
+
+	FlagNoEdit := flag.Bool("no-edit", false, "do not open the editor, write all cards as they are")
+	FlagReviewFlagged := flag.Bool("review-flagged", false, "only open the editor for cards failing a lint check")
+	flag.BoolVar(&reviewTUI, "tui", false, "review the cards in the terminal instead of an editor")
+	flag.StringVar(&editorCommand, "editor", "", "editor to review the cards with, instead of $VISUAL or $EDITOR")
+	flag.BoolVar(&resumeSession, "resume", false, "continue the review of an interrupted run")
+	flag.BoolVar(&exportAll, "all", false, "review and write all toggles, not only the ones new or changed since the last run")
+	flag.Var(&removedNotes, "removed", "what to do with the notes of toggles removed from Notion: keep, delete or suspend (needs -anki-connect)")
+	flag.StringVar(&serveAddr, "addr", serveAddr, "address of the review server of md2anki serve")
+	flag.DurationVar(&watchInterval, "interval", watchInterval, "time between two looks at the folder of md2anki watch")
 
 	OnlyMedia := flag.Bool("only-media", false, "only move media files")
 
 	Verbose := flag.Bool("verbose", false, "see what is happening")
 
-	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
-		fmt.Printf("Usage:\n\t%s%s <filepath> [-math] [-media] [-verbose]\n", CallPrefix, NAME)
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "serve":
+			serveMode = true
+			args = args[1:]
+		case "watch":
+			watchMode = true
+			args = args[1:]
+		}
+	}
+	if len(args) < 1 || strings.HasPrefix(args[0], "-") {
+		fmt.Printf("Usage:\n\t%s%s <filepath | export.zip | export dir> [-math] [-media] [-html] [-nested policy] [-apkg | -anki-connect] [-no-edit | -review-flagged] [-tui | -editor cmd] [-resume] [-all] [-removed policy] [-profile name] [-verbose]\n", CallPrefix, NAME)
+		fmt.Printf("\t%s%s serve <filepath | export.zip | export dir> [-addr host:port] [options]\n", CallPrefix, NAME)
+		fmt.Printf("\t%s%s watch <dir> [-interval 2s] [options]\n", CallPrefix, NAME)
 		return
 	}
+	os.Args = append(os.Args[:1], args...)
 
 	flag.CommandLine.Parse(os.Args[2:])
+	cfg, err := loadConfig(configFiles()...)
+	if err == nil {
+		err = cfg.apply(flag.CommandLine, profileName)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 	VERBOSE = *Verbose
-	if *OnlyMedia {
-		addMedia(os.Args[1])
-		return
+	if headingDepth < 1 || headingDepth > 6 {
+		log.Fatal("the heading depth must be between 1 and 6")
+	}
+	if subdeckDepth < 0 || subdeckDepth > headingDepth {
+		log.Fatalf("the subdeck depth must be between 0 and the heading depth %d", headingDepth)
+	}
+	if *tagMapFile != "" {
+		if err := loadTagMap(*tagMapFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if _, ok := codeThemes[codeThemeName]; !ok && codeThemeName != "none" {
+		log.Fatalf("unknown code theme %q, use one of %s", codeThemeName, codeThemeNames())
+	}
+	// a profile may pick another output than the command line.
+	if *FlagApkg && *FlagAnkiConnect {
+		log.Fatal("-apkg and -anki-connect exclude each other")
+	}
+	if *FlagNoEdit && *FlagReviewFlagged {
+		log.Fatal("-no-edit and -review-flagged exclude each other")
+	}
+	// cloze deletions must be marked before the math conversion touches the
+	// text.
+	if clozeMarker != "" {
+		mutations = append(mutations, toCloze)
 	}
-
 	if *FlagToMathJax {
 		mutations = append(mutations, toMathJax)
 	}
 	if *FlagIncludeMedia {
 		mutations = append(mutations, includeMedia)
 	}
+	// rendering comes last, so the <img> of the media files are kept.
+	if *FlagToHTML {
+		mutations = append(mutations, toHTML)
+	}
 
-	if err := Process(os.Args[1], mutations...); err != nil {
-		log.Fatal(err)
+	out := outputText
+	switch {
+	case *FlagApkg:
+		out = outputApkg
+	case *FlagAnkiConnect:
+		out = outputAnkiConnect
 	}
-	if *FlagIncludeMedia {
-		addMedia(os.Args[1])
+
+	rev := reviewAll
+	switch {
+	case *FlagNoEdit:
+		rev = reviewNone
+	case *FlagReviewFlagged:
+		rev = reviewFlagged
+	}
+
+	if watchMode {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		err := watch(ctx, os.Args[1], out, *FlagIncludeMedia, mutations...)
+		stop()
+		if err != nil {
+			log.Println(err)
+			os.Exit(exitFailure)
+		}
+		return
+	}
+
+	pages := []page{{fp: os.Args[1], deck: pageTitleToDeckName(os.Args[1])}}
+	if isWorkspace(os.Args[1]) {
+		if pages, err = workspacePages(os.Args[1]); err != nil {
+			log.Println(err)
+			os.Exit(exitRead)
+		}
+	}
+
+	if *OnlyMedia {
+		for _, p := range pages {
+			addMedia(p.fp)
+		}
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	err = ProcessPages(ctx, pages, os.Args[1], out, rev, mutations...)
+	stop()
+	if err != nil {
+		log.Println(err)
+		os.Exit(exitCode(err))
+	}
+	// the package and AnkiConnect already take care of the media files.
+	if *FlagIncludeMedia && out == outputText {
+		for _, p := range pages {
+			addMedia(p.fp)
+		}
 	}
 }
 
+// Exit codes of md2anki, depending on the stage which failed.
+const (
+	exitFailure     = 1
+	exitRead        = 3
+	exitReview      = 4
+	exitWrite       = 5
+	exitInterrupted = 130
+)
+
+func exitCode(err error) int {
+	if errors.Is(err, context.Canceled) {
+		return exitInterrupted
+	}
+	var pe *PipelineError
+	if !errors.As(err, &pe) {
+		return exitFailure
+	}
+	switch pe.Stage {
+	case stageRead:
+		return exitRead
+	case stageReview:
+		return exitReview
+	case stageWrite:
+		return exitWrite
+	default:
+		return exitFailure
+	}
+}
+
+// ankiProfile is the name of the Anki profile media files are moved to. It is
+// only asked for once.
+var ankiProfile string
+
+// ankiMediaDir is the collection.media folder media files are moved to. If it
+// is empty, the one of ankiProfile is used.
+var ankiMediaDir string
+
 func addMedia(forFp string) {
-	ext := filepath.Ext(forFp)
-	exportedMediaDp := forFp[:len(forFp)-len(ext)]
+	exportedMediaDp := mediaDir(forFp)
 	if !exists(exportedMediaDp) {
 		fmt.Printf("Cannot locate the exported media folder. Tried %q\n", exportedMediaDp)
 		return
@@ -96,68 +262,50 @@ Retry to move only the media files with:
 		log.Println(err)
 		return
 	}
-	names, err := dir.Readdirnames(-1)
+	entries, err := dir.ReadDir(-1)
 	if err != nil {
 		log.Println(err)
 		return
 	}
+	// in a workspace export the folder also holds the sub pages.
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() || strings.EqualFold(filepath.Ext(e.Name()), ".md") || isHTMLPage(e.Name()) {
+			continue
+		}
+		names = append(names, e.Name())
+	}
 	if len(names) == 0 {
 		log.Printf("There are no files in %q.\n", exportedMediaDp)
 		failed = false
 		return
 	}
 
-	
-	// see https://docs.ankiweb.net/files.html
-	var dp string
-	var ok bool
-	switch runtime.GOOS {
-	case "windows":
-		dp, ok = os.LookupEnv("APPDATA")
-		if !ok {
-			log.Println("Failed to find %APPDATA%")
-			return
+	// users with AnkiConnect do not need to tell us where their collection is.
+	if n, err := addMediaAnkiConnect(exportedMediaDp, names); err != errNoAnkiConnect {
+		if err != nil {
+			log.Println(err)
 		}
-		dp += `\Anki2`
-	case "darwin":
-		dp, ok = os.LookupEnv("HOME")
-		if !ok {
-			log.Println("Failed to find $HOME")
+		log.Printf("Stored %d media files through AnkiConnect.\n", n)
+		failed = len(names) != n
+		return
+	}
+
+	dp := ankiMediaDir
+	if dp == "" {
+		if dp = collectionMediaDir(); dp == "" {
 			return
 		}
-		dp += `/Library/Application Support/Anki2`
-	case "linux":
-		dp, ok = os.LookupEnv("XDG_DATA_HOME")
-		dp += `/Anki2`
-		if !ok || !exists(dp) {
-			dp, ok = os.LookupEnv("HOME")
-			if !ok {
-				log.Println("Failed to find $HOME")
-				return
-			}
-			dp += `/.local/share/Anki2`
-		}
-	default:
-		log.Fatalf("%q is not supported.", runtime.GOOS)
 	}
-	var username string
-	fmt.Println("What is your Anki profile name?")
-    scanner := bufio.NewScanner(os.Stdin)
-    if scanner.Scan() {
-        username = scanner.Text()
-    }
-
-    dp = filepath.Join(dp, username, "collection.media")
 	if !exists(dp) {
 		fmt.Printf("Could not find mediapath %q.\n", dp)
 		return
 	}
 
 	var moved int
-	exportedDirname := filepath.Base(exportedMediaDp)
 	for _, name := range names {
 		oldFp := filepath.Join(exportedMediaDp, name)
-		newName := exportedDirname + "_" + name
+		newName := mediaName(exportedMediaDp, name)
 		// location of media files url escaped by notion, thus in note links.
 		newFp := strings.Replace(filepath.Join(dp, newName), " ", "%20", -1)
 
@@ -180,3 +328,50 @@ func exists(fp string) bool {
 	_, err := os.Stat(fp)
 	return err == nil || errors.Is(err, os.ErrExist)
 }
+
+// collectionMediaDir returns the collection.media folder of ankiProfile, which
+// is asked for if it is not known yet. It is empty if the Anki folder cannot
+// be found.
+func collectionMediaDir() string {
+	// see https://docs.ankiweb.net/files.html
+	var dp string
+	var ok bool
+	switch runtime.GOOS {
+	case "windows":
+		dp, ok = os.LookupEnv("APPDATA")
+		if !ok {
+			log.Println("Failed to find %APPDATA%")
+			return ""
+		}
+		dp += `\Anki2`
+	case "darwin":
+		dp, ok = os.LookupEnv("HOME")
+		if !ok {
+			log.Println("Failed to find $HOME")
+			return ""
+		}
+		dp += `/Library/Application Support/Anki2`
+	case "linux":
+		dp, ok = os.LookupEnv("XDG_DATA_HOME")
+		dp += `/Anki2`
+		if !ok || !exists(dp) {
+			dp, ok = os.LookupEnv("HOME")
+			if !ok {
+				log.Println("Failed to find $HOME")
+				return ""
+			}
+			dp += `/.local/share/Anki2`
+		}
+	default:
+		log.Fatalf("%q is not supported.", runtime.GOOS)
+	}
+	if ankiProfile == "" {
+		fmt.Println("What is your Anki profile name?")
+		scanner := bufio.NewScanner(os.Stdin)
+		if scanner.Scan() {
+			ankiProfile = scanner.Text()
+		}
+	}
+
+	return filepath.Join(dp, ankiProfile, "collection.media")
+}
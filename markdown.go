@@ -0,0 +1,382 @@
+package main
+
+import (
+	"strings"
+)
+
+// The Notion Markdown export is parsed into a small block-level document
+// tree. Only the blocks md2anki cares about get their own node kind, every
+// other line ends up in a paragraph.
+/*
+- toggle front        -> nodeListItem{text: "toggle front", children: ...}
+
+	toggle back       -> nodeParagraph (child of the list item)
+
+# heading             -> nodeHeading{level: 1, text: "heading"}
+
+heading               -> nodeHeading{level: 2, text: "heading"}
+-------
+
+```python
+# comment             -> part of nodeCodeFence, never a heading.
+```
+*/
+
+type nodeKind int
+
+const (
+	nodeParagraph nodeKind = iota
+	nodeHeading
+	nodeListItem
+	nodeCodeFence
+)
+
+func (k nodeKind) String() string {
+	switch k {
+	case nodeParagraph:
+		return "paragraph"
+	case nodeHeading:
+		return "heading"
+	case nodeListItem:
+		return "list item"
+	case nodeCodeFence:
+		return "code fence"
+	default:
+		return "unknown"
+	}
+}
+
+type node struct {
+	kind nodeKind
+
+	// level is the heading level (1-6) of a nodeHeading.
+	level int
+
+	// marker is the bullet ('-', '*', '+') or the delimiter ('.', ')') of an
+	// ordered nodeListItem.
+	marker byte
+
+	// text is the heading text without #, the first line of a list item
+	// without marker, the joined lines of a paragraph or the content of a code
+	// fence. It never ends with a newline, except for code fences.
+	text []byte
+
+	// lang is the info string of a nodeCodeFence.
+	lang string
+
+	// body is the de-indented source of all the blocks nested in a list item,
+	// ending with a single newline. It is empty if there are no nested blocks.
+	body []byte
+
+	// children are the parsed blocks of body.
+	children []*node
+
+	// spaced reports whether a blank line separates the text of a list item
+	// from its body, which Notion writes for toggles only.
+	spaced bool
+
+	// src is the source of the block including the blank lines between it and
+	// the previous block, so joining the src of all children gives the body
+	// of their parent.
+	src []byte
+}
+
+// isToggle reports whether n is the Markdown representation of a Notion
+// toggle: a dash bullet with nested content after a blank line. A nested
+// bullet list or a continuation line right below the bullet is no toggle.
+func (n *node) isToggle() bool {
+	return n.kind == nodeListItem && n.marker == '-' && n.spaced && len(n.children) > 0
+}
+
+// tabWidth is the column width of a tab used for indentation.
+const tabWidth = 4
+
+// parseMarkdown parses raw into its top level blocks. CRLF and CR line endings
+// are accepted and normalised.
+func parseMarkdown(raw []byte) []*node {
+	s := strings.ReplaceAll(string(raw), "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return parseBlocks(strings.Split(s, "\n"))
+}
+
+func parseBlocks(lines []string) []*node {
+	var nodes []*node
+	prevEnd := 0 // end of the previous block
+	add := func(n *node, end int) {
+		n.src = []byte(strings.Join(lines[prevEnd:end], "\n") + "\n")
+		nodes = append(nodes, n)
+		prevEnd = end
+	}
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		if isBlank(line) {
+			i++
+			continue
+		}
+
+		if n, end := parseFence(lines, i); n != nil {
+			add(n, end)
+			i = end
+			continue
+		}
+		if n := parseHeading(line); n != nil {
+			add(n, i+1)
+			i++
+			continue
+		}
+		if n, end := parseListItem(lines, i); n != nil {
+			add(n, end)
+			i = end
+			continue
+		}
+
+		// paragraph: runs until a blank line or the start of another block.
+		// An underline of = or - turns it into a heading.
+		start := i
+		level := 0
+		for i++; i < len(lines); i++ {
+			if level = setextLevel(lines, start, i); level > 0 || isBlank(lines[i]) || startsBlock(lines, i) {
+				break
+			}
+		}
+		text := strings.Join(trimLines(lines[start:i]), "\n")
+		if level > 0 {
+			add(&node{kind: nodeHeading, level: level, text: []byte(text)}, i+1)
+			i++
+			continue
+		}
+		add(&node{kind: nodeParagraph, text: []byte(text)}, i)
+	}
+	return nodes
+}
+
+func startsBlock(lines []string, i int) bool {
+	if n, _ := parseFence(lines, i); n != nil {
+		return true
+	}
+	if parseHeading(lines[i]) != nil {
+		return true
+	}
+	_, _, ok := listMarker(lines[i])
+	return ok
+}
+
+// parseFence parses a fenced code block starting at lines[i]. It returns the
+// index of the line after the closing fence. An unterminated fence runs until
+// the end of lines.
+func parseFence(lines []string, i int) (*node, int) {
+	indent, rest := splitIndent(lines[i])
+	if indent > 3 || len(rest) < 3 || (rest[0] != '`' && rest[0] != '~') {
+		return nil, i
+	}
+	fc := rest[0]
+	width := len(rest) - len(strings.TrimLeft(rest, string(fc)))
+	if width < 3 {
+		return nil, i
+	}
+	info := strings.TrimSpace(rest[width:])
+	if fc == '`' && strings.Contains(info, "`") {
+		return nil, i
+	}
+	n := &node{kind: nodeCodeFence}
+	if fs := strings.Fields(info); len(fs) > 0 {
+		n.lang = fs[0]
+	}
+
+	var content strings.Builder
+	j := i + 1
+	for ; j < len(lines); j++ {
+		ind, r := splitIndent(lines[j])
+		r = strings.TrimRight(r, " \t")
+		if ind <= 3 && len(r) >= width && strings.Trim(r, string(fc)) == "" {
+			j++
+			break
+		}
+		content.WriteString(dedent(lines[j], indent))
+		content.WriteByte('\n')
+	}
+	n.text = []byte(content.String())
+	return n, j
+}
+
+// parseHeading parses an ATX heading, e.g. "## foo bar ##".
+func parseHeading(line string) *node {
+	indent, rest := splitIndent(line)
+	if indent > 3 || !strings.HasPrefix(rest, "#") {
+		return nil
+	}
+	level := len(rest) - len(strings.TrimLeft(rest, "#"))
+	if level > 6 {
+		return nil
+	}
+	rest = rest[level:]
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return nil
+	}
+	text := strings.TrimSpace(rest)
+	// optional closing sequence
+	if t := strings.TrimRight(text, "#"); t != text && (t == "" || strings.HasSuffix(t, " ") || strings.HasSuffix(t, "\t")) {
+		text = strings.TrimSpace(t)
+	}
+	return &node{kind: nodeHeading, level: level, text: []byte(text)}
+}
+
+// setextLevel returns the level of the heading if lines[i] underlines the
+// paragraph lines[start:i] with = (h1) or - (h2), else 0. Tables and quotes
+// are not underlined.
+func setextLevel(lines []string, start, i int) int {
+	indent, rest := splitIndent(lines[i])
+	rest = strings.TrimRight(rest, " \t")
+	if indent > 3 || rest == "" || strings.HasPrefix(strings.TrimSpace(lines[start]), ">") {
+		return 0
+	}
+	switch {
+	case strings.Trim(rest, "=") == "":
+		return 1
+	case strings.Trim(rest, "-") == "" && !isTable(trimLines(lines[start:i+1])):
+		return 2
+	}
+	return 0
+}
+
+// listMarker reports the marker of a list item line and the column its
+// content starts at.
+func listMarker(line string) (marker byte, contentCol int, ok bool) {
+	indent, rest := splitIndent(line)
+	if indent > 3 || rest == "" {
+		return 0, 0, false
+	}
+	var width int
+	switch rest[0] {
+	case '-', '*', '+':
+		marker, width = rest[0], 1
+	default:
+		d := 0
+		for d < len(rest) && d < 9 && rest[d] >= '0' && rest[d] <= '9' {
+			d++
+		}
+		if d == 0 || d == len(rest) || (rest[d] != '.' && rest[d] != ')') {
+			return 0, 0, false
+		}
+		marker, width = rest[d], d+1
+	}
+	if len(rest) > width && rest[width] != ' ' && rest[width] != '\t' {
+		return 0, 0, false
+	}
+	// "---" and "* * *" are thematic breaks, not list items.
+	if t := strings.ReplaceAll(rest, " ", ""); len(t) >= 3 && strings.Trim(t, string(rest[0])) == "" {
+		return 0, 0, false
+	}
+	return marker, indent + width + 1, true
+}
+
+// parseListItem parses a list item starting at lines[i]. Every following line
+// which is blank or indented to at least the content column of the item
+// belongs to its body. It returns the index of the first line after the item.
+func parseListItem(lines []string, i int) (*node, int) {
+	marker, contentCol, ok := listMarker(lines[i])
+	if !ok {
+		return nil, i
+	}
+	_, rest := splitIndent(lines[i])
+	rest = strings.TrimLeft(rest, "0123456789")
+	n := &node{
+		kind:   nodeListItem,
+		marker: marker,
+		text:   []byte(strings.TrimSpace(rest[1:])),
+	}
+
+	j := i + 1
+	last := i // last non blank line of the item
+	for ; j < len(lines); j++ {
+		if isBlank(lines[j]) {
+			continue
+		}
+		if ind, _ := splitIndent(lines[j]); ind < contentCol {
+			break
+		}
+		last = j
+	}
+	body := lines[i+1 : last+1]
+	n.spaced = len(body) > 0 && isBlank(body[0])
+
+	// strip the common indentation, so a mix of tabs and spaces in the
+	// export does not matter.
+	min := -1
+	for _, l := range body {
+		if isBlank(l) {
+			continue
+		}
+		if ind, _ := splitIndent(l); min == -1 || ind < min {
+			min = ind
+		}
+	}
+	for len(body) > 0 && isBlank(body[0]) {
+		body = body[1:]
+	}
+	if len(body) > 0 {
+		dedented := make([]string, len(body))
+		for k, l := range body {
+			dedented[k] = dedent(l, min)
+		}
+		n.body = []byte(strings.Join(dedented, "\n") + "\n")
+		n.children = parseBlocks(dedented)
+	}
+	return n, last + 1
+}
+
+// splitIndent returns the indentation width in columns and the rest of line.
+func splitIndent(line string) (int, string) {
+	col := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			col++
+		case '\t':
+			col += tabWidth - col%tabWidth
+		default:
+			return col, line[i:]
+		}
+	}
+	return col, ""
+}
+
+// dedent removes up to cols columns of indentation from line.
+func dedent(line string, cols int) string {
+	col := 0
+	for i := 0; i < len(line); i++ {
+		if col >= cols {
+			return line[i:]
+		}
+		switch line[i] {
+		case ' ':
+			col++
+		case '\t':
+			next := col + tabWidth - col%tabWidth
+			if next > cols {
+				// a tab spanning the cut keeps the remaining columns as spaces.
+				return strings.Repeat(" ", next-cols) + line[i+1:]
+			}
+			col = next
+		default:
+			return line[i:]
+		}
+	}
+	return ""
+}
+
+func trimLines(lines []string) []string {
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		out[i] = strings.TrimSpace(l)
+	}
+	return out
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
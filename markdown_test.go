@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+)
+
+// chdir changes into dp until the end of the test, like t.Chdir of Go 1.24.
+func chdir(t *testing.T, dp string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dp); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func collectCards(raw string) []card2 {
+	return collectPageCards("Page abc.md", raw)
+}
+
+func collectPageCards(fp, raw string) []card2 {
+	blocks := make(chan *node)
+	cards := make(chan card2)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go findBlocks(context.Background(), parseDocument(fp, []byte(raw)), blocks, &wg)
+	go combine(context.Background(), page{fp: fp, deck: pageTitleToDeckName(fp)}, blocks, cards, &wg)
+
+	var cs []card2
+	for c := range cards {
+		cs = append(cs, c)
+	}
+	wg.Wait()
+	return cs
+}
+
+func TestParseToggles(t *testing.T) {
+	tests := []struct {
+		name  string
+		raw   string
+		front string
+		back  string
+	}{
+		{
+			name:  "spaces",
+			raw:   "# Page\n\n- front\n\n    line 1\n    line 2\n\n",
+			front: "front",
+			back:  "line 1\nline 2\n",
+		},
+		{
+			name:  "crlf",
+			raw:   "# Page\r\n\r\n- front\r\n\r\n    line 1\r\n    line 2\r\n\r\n",
+			front: "front",
+			back:  "line 1\nline 2\n",
+		},
+		{
+			name:  "tabs and spaces",
+			raw:   "# Page\n\n- front\n\n\tline 1\n    line 2\n\t    indented\n",
+			front: "front",
+			back:  "line 1\nline 2\n    indented\n",
+		},
+		{
+			name:  "no trailing blank line",
+			raw:   "# Page\n\n- front\n\n    line 1",
+			front: "front",
+			back:  "line 1\n",
+		},
+		{
+			name:  "code fence",
+			raw:   "# Page\n\n- front\n\n    ```python\n    # comment\n\n    x = 1\n    ```\n",
+			front: "front",
+			back:  "```python\n# comment\n\nx = 1\n```\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := collectCards(tt.raw)
+			if len(cs) != 1 {
+				t.Fatalf("got %d cards, want 1: %v", len(cs), cs)
+			}
+			if got := string(cs[0].front); got != tt.front {
+				t.Errorf("front = %q, want %q", got, tt.front)
+			}
+			if got := string(cs[0].back); got != tt.back {
+				t.Errorf("back = %q, want %q", got, tt.back)
+			}
+		})
+	}
+}
+
+func TestHeadingTags(t *testing.T) {
+	raw := `# Page
+
+# ABC
+
+- a
+
+    back
+
+## b
+
+` + "```" + `
+# not a heading
+` + "```" + `
+
+### more detailed
+
+- b
+
+    back
+
+## c
+
+- c
+
+    back
+`
+	want := []string{"ABC", "ABC b more_detailed", "ABC c"}
+	cs := collectCards(raw)
+	if len(cs) != len(want) {
+		t.Fatalf("got %d cards, want %d", len(cs), len(want))
+	}
+	for i, c := range cs {
+		var got string
+		for j, tag := range c.tags {
+			if j > 0 {
+				got += " "
+			}
+			got += string(tag)
+		}
+		if got != want[i] {
+			t.Errorf("card %d: tags = %q, want %q", i, got, want[i])
+		}
+	}
+}
+
+func TestHeadingLevels(t *testing.T) {
+	raw := `Page
+====
+
+Part **one**
+------------
+
+#### [Graph](Graph%20abc.md) ` + "`BFS`" + `
+
+###### _deep_
+
+- a
+
+    back
+
+#####
+
+- b
+
+    back
+
+Part two
+---
+
+- c
+
+    back
+`
+	defer func() { headingDepth = 3 }()
+	headingDepth = 6
+	want := []string{"Part_one Graph_BFS deep", "Part_one Graph_BFS", "Part_two"}
+	cs := collectCards(raw)
+	if len(cs) != len(want) {
+		t.Fatalf("got %d cards, want %d", len(cs), len(want))
+	}
+	for i, c := range cs {
+		if got := string(joinTags(c.tags)); got != want[i] {
+			t.Errorf("card %d: tags = %q, want %q", i, got, want[i])
+		}
+	}
+
+	// a thematic break after a blank line is no heading.
+	if nodes := parseMarkdown([]byte("text\n\n---\n")); len(nodes) != 2 || nodes[1].kind != nodeParagraph {
+		t.Errorf("thematic break parsed as %v", nodes)
+	}
+}
+
+func TestPlainListIsNoToggle(t *testing.T) {
+	for _, raw := range []string{
+		"# Page\n\n- one\n- two\n\n1. three\n\n    four\n",
+		"# Page\n\n- fruit\n    - apple\n    - pear\n",
+		"# Page\n\n- item one\n  continued line\n",
+	} {
+		if cs := collectCards(raw); len(cs) != 0 {
+			t.Errorf("%q: got %d cards, want 0: %v", raw, len(cs), cs)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+)
+
+// Toggles inside of toggles are handled by the nested policy:
+/*
+- What is entropy?
+
+	The average information.
+
+	- Why average?
+
+		Over all outcomes.
+*/
+// keep:    the nested toggle stays in the back as it is written.
+// cards:   "Why average?" becomes a card of its own, tagged with its parent
+//          front, and is removed from the back of "What is entropy?".
+// details: the nested toggle becomes a collapsed <details> in the back.
+// drop:    the nested toggle is removed from the back.
+
+type nestedPolicy int
+
+const (
+	nestedKeep nestedPolicy = iota
+	nestedCards
+	nestedDetails
+	nestedDrop
+)
+
+var nestedPolicyNames = []string{"keep", "cards", "details", "drop"}
+
+// nestedToggles is the policy for toggles inside of toggles.
+var nestedToggles = nestedKeep
+
+func (p nestedPolicy) String() string { return nestedPolicyNames[p] }
+
+// Set implements flag.Value.
+func (p *nestedPolicy) Set(s string) error {
+	for i, name := range nestedPolicyNames {
+		if s == name {
+			*p = nestedPolicy(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown policy %q, use one of %v", s, nestedPolicyNames)
+}
+
+// toggleBack returns the back of the toggle t after applying the policy to
+// the toggles nested in it. With nestedCards these toggles are returned to be
+// turned into cards of their own.
+func toggleBack(t *node, policy nestedPolicy, isHTML bool) (back []byte, nested []*node) {
+	if policy == nestedKeep || !hasNestedToggle(t) {
+		return t.body, nil
+	}
+	var buf bytes.Buffer
+	for _, c := range t.children {
+		if !c.isToggle() {
+			buf.Write(c.src)
+			continue
+		}
+		switch policy {
+		case nestedCards:
+			nested = append(nested, c)
+		case nestedDetails:
+			buf.Write(leadingBlankLines(c.src))
+			buf.Write(detailsHTML(c, isHTML))
+		}
+	}
+	back = bytes.TrimSpace(buf.Bytes())
+	if len(back) == 0 {
+		return nil, nested
+	}
+	return append(back, '\n'), nested
+}
+
+// frontTag turns the front of a toggle into a tag for the cards split off of
+// it.
+func frontTag(front []byte, isHTML bool) []byte {
+	text := string(front)
+	if isHTML {
+		text = html.UnescapeString(stripHTML(text))
+	} else {
+		text = plainInline(text)
+	}
+	return []byte(tagName(text))
+}
+
+func hasNestedToggle(t *node) bool {
+	for _, c := range t.children {
+		if c.isToggle() {
+			return true
+		}
+	}
+	return false
+}
+
+// detailsHTML renders the toggle t as a collapsed <details> element, with its
+// own nested toggles rendered the same way.
+func detailsHTML(t *node, isHTML bool) []byte {
+	front := t.text
+	if !isHTML {
+		front = []byte(html.EscapeString(string(front)))
+	}
+	body, _ := toggleBack(t, nestedDetails, isHTML)
+	var buf bytes.Buffer
+	buf.WriteString("<details><summary>")
+	buf.Write(front)
+	buf.WriteString("</summary>")
+	if !isHTML {
+		// a blank line lets Markdown inside of the element be rendered.
+		buf.WriteString("\n\n")
+	}
+	buf.Write(bytes.TrimSpace(body))
+	if !isHTML {
+		buf.WriteString("\n\n")
+	}
+	buf.WriteString("</details>\n")
+	return buf.Bytes()
+}
+
+// leadingBlankLines returns the blank lines separating src from the previous
+// block.
+func leadingBlankLines(src []byte) []byte {
+	content := bytes.TrimLeft(src, " \t\r\n")
+	return src[:bytes.LastIndexByte(src[:len(src)-len(content)], '\n')+1]
+}
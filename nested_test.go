@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNestedToggles(t *testing.T) {
+	const raw = "# Page\n\n- What is entropy?\n\n\tThe average information.\n\n\t- Why average?\n\n\t\tOver all outcomes.\n\n\tEnd.\n"
+	tests := []struct {
+		policy nestedPolicy
+		backs  []string
+		tags   []string
+	}{
+		{nestedKeep, []string{"The average information.\n\n- Why average?\n\n\tOver all outcomes.\n\nEnd.\n"}, []string{""}},
+		{nestedDrop, []string{"The average information.\n\nEnd.\n"}, []string{""}},
+		{nestedCards, []string{"The average information.\n\nEnd.\n", "Over all outcomes.\n"}, []string{"", "What_is_entropy?"}},
+		{nestedDetails, []string{"The average information.\n\n<details><summary>Why average?</summary>\n\nOver all outcomes.\n\n</details>\n\nEnd.\n"}, []string{""}},
+	}
+	defer func(p nestedPolicy) { nestedToggles = p }(nestedToggles)
+	for _, tt := range tests {
+		nestedToggles = tt.policy
+		cs := collectCards(raw)
+		if len(cs) != len(tt.backs) {
+			t.Fatalf("%v: got %d cards, want %d", tt.policy, len(cs), len(tt.backs))
+		}
+		for i, c := range cs {
+			if string(c.back) != tt.backs[i] {
+				t.Errorf("%v: card %d back = %q, want %q", tt.policy, i, c.back, tt.backs[i])
+			}
+			var tags []string
+			for _, tag := range c.tags {
+				tags = append(tags, string(tag))
+			}
+			if got := strings.Join(tags, " "); got != tt.tags[i] {
+				t.Errorf("%v: card %d tags = %q, want %q", tt.policy, i, got, tt.tags[i])
+			}
+		}
+	}
+}
+
+func TestNestedTogglesHTML(t *testing.T) {
+	const raw = `<html><body><header><h1 class="page-title">Page</h1></header><div class="page-body">` +
+		`<ul class="toggle"><li><details open=""><summary>Outer</summary><p>text</p>` +
+		`<ul class="toggle"><li><details open=""><summary>Inner</summary><p>inner</p></details></li></ul>` +
+		`</details></li></ul></div></body></html>`
+	defer func(p nestedPolicy) { nestedToggles = p }(nestedToggles)
+	nestedToggles = nestedCards
+	cs := collectPageCards("Page abc.html", raw)
+	if len(cs) != 2 {
+		t.Fatalf("got %d cards, want 2", len(cs))
+	}
+	if strings.Contains(string(cs[0].back), "Inner") {
+		t.Errorf("outer back = %q still contains the nested toggle", cs[0].back)
+	}
+	if string(cs[1].front) != "Inner" || !strings.Contains(string(cs[1].back), "inner") {
+		t.Errorf("nested card = %q / %q", cs[1].front, cs[1].back)
+	}
+}
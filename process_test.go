@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// writePage writes a page with a single toggle to the temporary working
+// directory of the test.
+func writePage(t *testing.T) string {
+	chdir(t, t.TempDir())
+	fp := "Page abc.md"
+	if err := os.WriteFile(fp, []byte("# Page\n\n- front\n\n\tback\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return fp
+}
+
+func TestProcessWriteError(t *testing.T) {
+	fp := writePage(t)
+	// the output cannot be created, because a folder is in the way.
+	if err := os.Mkdir(MdToAnkiFilename(fp), 0700); err != nil {
+		t.Fatal(err)
+	}
+	err := Process(context.Background(), fp, outputText, reviewNone)
+	var pe *PipelineError
+	if !errors.As(err, &pe) || pe.Stage != stageWrite {
+		t.Fatalf("err = %v, want a write error", err)
+	}
+	if exitCode(err) != exitWrite {
+		t.Errorf("exit code = %d, want %d", exitCode(err), exitWrite)
+	}
+}
+
+func TestProcessReadError(t *testing.T) {
+	chdir(t, t.TempDir())
+	err := Process(context.Background(), "Missing abc.md", outputText, reviewNone)
+	if exitCode(err) != exitRead || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("err = %v, want a read error", err)
+	}
+}
+
+func TestProcessEditorError(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses $EDITOR")
+	}
+	fp := writePage(t)
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", "false")
+
+	err := Process(context.Background(), fp, outputText, reviewAll)
+	if exitCode(err) != exitReview {
+		t.Fatalf("err = %v, want a review error", err)
+	}
+	if _, err := os.Stat(MdToAnkiFilename(fp)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the output of a failed run was written")
+	}
+	if left, _ := filepath.Glob(filepath.Join(tmp, "*")); len(left) > 0 {
+		t.Errorf("temporary files are left behind: %v", left)
+	}
+}
+
+func TestProcessCancelled(t *testing.T) {
+	fp := writePage(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Process(ctx, fp, outputText, reviewNone); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if _, err := os.Stat(MdToAnkiFilename(fp)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the output of a cancelled run was written")
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// A card is edited as a prompt document:
+/*
+# Lines starting with # before the first card are comments.
+--- card
+deck: Page::Child
+type: Basic
+tags: ABC b
+--- front
+What is entropy?
+--- back
+The average information.
+*/
+// The fields of the card header are optional. A file may hold several cards,
+// each starting with "--- card". A line of a front or back starting with
+// "---" is written as "\---". A file that is empty or starts with "skip" skips
+// the card.
+
+const (
+	promptCard  = "--- card"
+	promptFront = "--- front"
+	promptBack  = "--- back"
+
+	// promptErrorPrefix starts the comment telling about a parse error when
+	// the editor is opened again.
+	promptErrorPrefix = "# md2anki error: "
+)
+
+const promptHelp = `# Edit the card below and save the file. Delete everything or write "skip"
+# on the first line to skip the card. Copy everything from "--- card" on to
+# add more cards. type is Basic or Cloze, tags are separated by spaces.
+`
+
+var skipLit = []byte("skip")
+var skipNote = errors.New("Skip this note.")
+
+// promptError is a mistake in a prompt document.
+type promptError struct {
+	line int
+	msg  string
+}
+
+func (e *promptError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.line, e.msg)
+}
+
+// createPrompt writes the card as a prompt document to fp.
+func createPrompt(fp string, card *card2) error {
+	return os.WriteFile(fp, formatPrompt(card), 0600)
+}
+
+func formatPrompt(card *card2) []byte {
+	var b bytes.Buffer
+	b.WriteString(promptHelp)
+	b.WriteString(promptCard + "\n")
+	noteType, _, _ := noteFields(*card)
+	fmt.Fprintf(&b, "deck: %s\ntype: %s\ntags: %s\n", card.deck, noteType, bytes.Join(card.tags, []byte{' '}))
+	b.WriteString(promptFront + "\n")
+	writePromptText(&b, card.front)
+	b.WriteString(promptBack + "\n")
+	writePromptText(&b, card.back)
+	return b.Bytes()
+}
+
+func writePromptText(b *bytes.Buffer, text []byte) {
+	text = bytes.TrimRight(text, "\n")
+	if len(text) == 0 {
+		return
+	}
+	for _, line := range bytes.Split(text, []byte{'\n'}) {
+		if bytes.HasPrefix(line, []byte("---")) || bytes.HasPrefix(line, []byte(`\---`)) {
+			b.WriteByte('\\')
+		}
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+}
+
+// readPrompt reads in the user modified file. The user may include mutliple
+// notes in one file. If the file is empty or begins with "skip", readPrompt
+// returns the skipNote error. A mistake in the file is returned as a
+// *promptError.
+func readPrompt(fp string) ([]card2, error) {
+	raw, err := os.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+	return parsePrompt(raw)
+}
+
+func parsePrompt(raw []byte) ([]card2, error) {
+	s := strings.ReplaceAll(string(raw), "\r\n", "\n")
+	if t := strings.TrimSpace(s); t == "" || len(t) >= len(skipLit) && strings.EqualFold(t[:len(skipLit)], string(skipLit)) {
+		return nil, skipNote
+	}
+
+	var (
+		cards   []card2
+		c       *card2
+		section string // the section the following lines belong to.
+		text    []string
+		seen    map[string]bool // sections and fields of the current card.
+		start   int             // the line the current card starts at.
+	)
+	// flush ends the front or back section.
+	flush := func() {
+		joined := []byte(strings.TrimRight(strings.Join(text, "\n"), "\n"))
+		switch section {
+		case promptFront:
+			c.front = bytes.TrimSpace(joined)
+		case promptBack:
+			c.back = joined
+		}
+		text = nil
+	}
+	end := func() error {
+		if c == nil {
+			return nil
+		}
+		flush()
+		if !seen[promptFront] {
+			return &promptError{start, fmt.Sprintf("the card has no %q", promptFront)}
+		}
+		cards = append(cards, *c)
+		return nil
+	}
+
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	for i, line := range lines {
+		n := i + 1
+		if strings.HasPrefix(line, "---") {
+			marker := strings.TrimSpace(line)
+			switch marker {
+			case promptCard:
+				if err := end(); err != nil {
+					return nil, err
+				}
+				c, section, seen, start = &card2{}, promptCard, map[string]bool{}, n
+			case promptFront, promptBack:
+				if c == nil {
+					return nil, &promptError{n, fmt.Sprintf("%q before the first %q", marker, promptCard)}
+				}
+				if seen[marker] {
+					return nil, &promptError{n, fmt.Sprintf("the card has a second %q", marker)}
+				}
+				if marker == promptBack && !seen[promptFront] {
+					return nil, &promptError{n, fmt.Sprintf("%q must come after %q", promptBack, promptFront)}
+				}
+				flush()
+				section = marker
+				seen[marker] = true
+			default:
+				return nil, &promptError{n, fmt.Sprintf("unknown section %q, write \\%s if it is part of the text", line, line)}
+			}
+			continue
+		}
+
+		switch section {
+		case "":
+			if t := strings.TrimSpace(line); t != "" && !strings.HasPrefix(t, "#") {
+				return nil, &promptError{n, fmt.Sprintf("text before the first %q", promptCard)}
+			}
+		case promptCard:
+			if err := parsePromptField(c, line, seen); err != nil {
+				return nil, &promptError{n, err.Error()}
+			}
+		default:
+			// unescape \--- and \\--- of writePromptText.
+			if t := strings.TrimLeft(line, `\`); len(t) < len(line) && strings.HasPrefix(t, "---") {
+				line = line[1:]
+			}
+			text = append(text, line)
+		}
+	}
+	if err := end(); err != nil {
+		return nil, err
+	}
+	if len(cards) == 0 {
+		// there are only comments left.
+		return nil, skipNote
+	}
+	return cards, nil
+}
+
+// parsePromptField sets the field of the card header line.
+func parsePromptField(c *card2, line string, seen map[string]bool) error {
+	if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+		return nil
+	}
+	key, value, ok := strings.Cut(line, ":")
+	if !ok {
+		return fmt.Errorf("%q is no field, write it as name: value", line)
+	}
+	key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+	if seen[key] {
+		return fmt.Errorf("the field %q is set twice", key)
+	}
+	seen[key] = true
+	switch key {
+	case "deck":
+		c.deck = value
+	case "type":
+		switch {
+		case strings.EqualFold(value, basicNoteType):
+			c.cloze = false
+		case strings.EqualFold(value, clozeNoteType):
+			c.cloze = true
+		default:
+			return fmt.Errorf("unknown type %q, use %s or %s", value, basicNoteType, clozeNoteType)
+		}
+	case "tags":
+		for _, tag := range strings.Fields(value) {
+			c.tags = append(c.tags, []byte(tag))
+		}
+	default:
+		return fmt.Errorf("unknown field %q, use deck, type or tags", key)
+	}
+	return nil
+}
+
+// editPrompt opens the prompt document fp in the editor, the command line of
+// getCmd, and reads the cards from it. As long as the document has a mistake,
+// it is opened again with the mistake on top.
+func editPrompt(editor []string, fp string) ([]card2, error) {
+	for {
+		// an exec.Cmd only runs once.
+		if err := runCommand(exec.Command(editor[0], editor[1:]...)); err != nil {
+			return nil, err
+		}
+		cs, err := readPrompt(fp)
+		var perr *promptError
+		if !errors.As(err, &perr) {
+			return cs, err
+		}
+		log.Printf("%s: %v, opening it again.\n", fp, perr)
+		if err := markPromptError(fp, perr); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// markPromptError puts the parse error err on top of the prompt document fp,
+// so the user sees it when the editor is opened again. The error of a
+// previous attempt is replaced.
+func markPromptError(fp string, err *promptError) error {
+	raw, rerr := os.ReadFile(fp)
+	if rerr != nil {
+		return rerr
+	}
+	lines := strings.SplitAfter(string(raw), "\n")
+	var removed int
+	for removed < len(lines) && strings.HasPrefix(lines[removed], promptErrorPrefix) {
+		removed++
+	}
+	msg := fmt.Sprintf("%sline %d: %s\n", promptErrorPrefix, err.line-removed+1, err.msg)
+	return os.WriteFile(fp, []byte(msg+strings.Join(lines[removed:], "")), 0600)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPromptRoundTrip(t *testing.T) {
+	card := card2{
+		front: []byte("What is **entropy**?"),
+		back:  []byte("---\n\\---\nThe average.\n"),
+		tags:  [][]byte{[]byte("ABC"), []byte("b")},
+		deck:  "Page::Child",
+		cloze: true,
+	}
+	cs, err := parsePrompt(formatPrompt(&card))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 1 {
+		t.Fatalf("got %d cards, want 1", len(cs))
+	}
+	c := cs[0]
+	if string(c.front) != string(card.front) || string(c.back) != "---\n\\---\nThe average." ||
+		string(c.tags[1]) != "b" || c.deck != card.deck || !c.cloze {
+		t.Errorf("got %+v", c)
+	}
+}
+
+func TestParsePrompt(t *testing.T) {
+	tests := []struct {
+		name  string
+		raw   string
+		cards int
+		err   string
+	}{
+		{"two cards", "# comment\n--- card\n--- front\na\n--- back\nb\n--- card\ntags: x\n--- front\nc", 2, ""},
+		{"empty", "\n\n", 0, skipNote.Error()},
+		{"skip", "Skip\n--- card\n--- front\na\n", 0, skipNote.Error()},
+		{"comments only", promptHelp, 0, skipNote.Error()},
+		{"unknown field", "--- card\ndek: Page\n--- front\na\n", 0, `line 2: unknown field "dek"`},
+		{"unknown type", "--- card\ntype: Reversed\n--- front\na\n", 0, `line 2: unknown type "Reversed"`},
+		{"back first", "--- card\n--- back\nb\n--- front\na\n", 0, `line 2: "--- back" must come after "--- front"`},
+		{"no front", "--- card\ntags: a\n--- card\n--- front\na", 0, `line 1: the card has no "--- front"`},
+		{"unknown section", "--- card\n--- front\na\n--- notes\n", 0, `line 4: unknown section "--- notes"`},
+		{"text before card", "front\n--- card\n--- front\na", 0, `line 1: text before the first "--- card"`},
+	}
+	for _, tt := range tests {
+		cs, err := parsePrompt([]byte(tt.raw))
+		if tt.err == "" {
+			if err != nil || len(cs) != tt.cards {
+				t.Errorf("%s: got %d cards, %v, want %d cards", tt.name, len(cs), err, tt.cards)
+			}
+			continue
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestEditPromptReopens(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	fp := filepath.Join(t.TempDir(), "card.txt")
+	if err := createPrompt(fp, &card2{front: []byte("front")}); err != nil {
+		t.Fatal(err)
+	}
+	// the first save has a mistake, the second fixes it, if the mistake is
+	// shown on the first line.
+	editor := []string{"sh", "-c", `
+if [ -f "$0.once" ]; then
+	head -n 1 "$0" | grep -q '^# md2anki error: line 4: unknown field "dek"' && printf -- '--- card\n--- front\nfixed\n' > "$0"
+else
+	touch "$0.once"
+	printf -- '# comment\n--- card\ndek: x\n--- front\na\n' > "$0"
+fi`, fp}
+	cs, err := editPrompt(editor, fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 1 || string(cs[0].front) != "fixed" {
+		t.Errorf("got %v, want the fixed card", cs)
+	}
+}
+
+func TestMarkPromptError(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "card.txt")
+	if err := os.WriteFile(fp, []byte(promptErrorPrefix+"old\n--- card\nx\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := readPrompt(fp)
+	perr, ok := err.(*promptError)
+	if !ok {
+		t.Fatalf("err = %v, want a *promptError", err)
+	}
+	if err := markPromptError(fp, perr); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := os.ReadFile(fp)
+	want := promptErrorPrefix + `line 3: "x" is no field, write it as name: value` + "\n--- card\nx\n"
+	if string(raw) != want {
+		t.Errorf("got %q, want %q", raw, want)
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// toHTML mutation: the fields of a card are written as Notion's Markdown,
+// which Anki shows literally. toHTML renders them to HTML:
+/*
+The **average** information:	->	<p>The <strong>average</strong> information:</p>
+- of $X$						->	<ul><li>of $X$</li></ul>
+*/
+// Math is cut out before rendering and put back unchanged afterwards, so
+// MathJax still finds it and "$a*b*c$" does not become emphasis.
+// Raw HTML is escaped unless it is one of allowedTags, which md2anki itself
+// produces for media files and nested toggles.
+
+// renderMarkdown renders the Markdown text to HTML.
+func renderMarkdown(text []byte) []byte {
+	src, math := protectMath(string(text))
+	var b strings.Builder
+	renderBlocks(&b, parseMarkdown([]byte(src)))
+	return []byte(restoreMath(strings.TrimSpace(b.String()), math))
+}
+
+// renderMarkdownLine renders text like renderMarkdown, but without wrapping
+// a single paragraph into <p>, which is what a front usually is.
+func renderMarkdownLine(text []byte) []byte {
+	out := renderMarkdown(text)
+	inner := strings.TrimSuffix(strings.TrimPrefix(string(out), "<p>"), "</p>")
+	if len(inner)+len("<p></p>") == len(out) && !strings.Contains(inner, "<p>") {
+		return []byte(inner)
+	}
+	return out
+}
+
+// mathExp matches the math expressions of Notion and MathJax. $$ must come
+// before $, so display math is not taken for two inline expressions.
+var mathExp = regexp.MustCompile(`(?s)\$\$.+?\$\$|\\\[.+?\\\]|\\\(.+?\\\)|\$[^$\n]+?\$`)
+
+// protectMath replaces every math expression with a placeholder no renderer
+// touches: a single rune of the private use area, which the highlighter of
+// code blocks does not split either.
+func protectMath(s string) (string, []string) {
+	var math []string
+	s = mathExp.ReplaceAllStringFunc(s, func(m string) string {
+		if len(math) > placeholderLast-placeholderFirst {
+			return m
+		}
+		math = append(math, m)
+		return string(rune(placeholderFirst + len(math) - 1))
+	})
+	return s, math
+}
+
+const (
+	placeholderFirst = 0xE000
+	placeholderLast  = 0xF8FF
+)
+
+func restoreMath(s string, math []string) string {
+	if len(math) == 0 {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if i := int(r) - placeholderFirst; i >= 0 && i < len(math) {
+			b.WriteString(html.EscapeString(math[i]))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func renderBlocks(b *strings.Builder, nodes []*node) {
+	for i := 0; i < len(nodes); i++ {
+		n := nodes[i]
+		switch n.kind {
+		case nodeHeading:
+			fmt.Fprintf(b, "<h%d>%s</h%d>\n", n.level, renderInline(string(n.text)), n.level)
+		case nodeCodeFence:
+			renderCode(b, n)
+		case nodeListItem:
+			// consecutive items of the same kind form one list.
+			j := i + 1
+			for j < len(nodes) && nodes[j].kind == nodeListItem && isOrdered(nodes[j]) == isOrdered(n) {
+				j++
+			}
+			renderList(b, nodes[i:j])
+			i = j - 1
+		default:
+			renderParagraph(b, string(n.text))
+		}
+	}
+}
+
+func isOrdered(n *node) bool {
+	return n.marker == '.' || n.marker == ')'
+}
+
+func renderList(b *strings.Builder, items []*node) {
+	tag := "ul"
+	if isOrdered(items[0]) {
+		tag = "ol"
+	}
+	b.WriteString("<" + tag + ">\n")
+	for _, it := range items {
+		b.WriteString("<li>")
+		b.WriteString(renderInline(string(it.text)))
+		if len(it.children) > 0 {
+			b.WriteString("\n")
+			renderBlocks(b, it.children)
+		}
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</" + tag + ">\n")
+}
+
+// renderCode renders a fenced code block, highlighted with codeThemeName.
+func renderCode(b *strings.Builder, n *node) {
+	if theme, ok := codeThemes[codeThemeName]; ok {
+		b.WriteString(highlightCode(strings.TrimSuffix(string(n.text), "\n"), n.lang, theme) + "\n")
+		return
+	}
+	class := ""
+	if n.lang != "" {
+		class = ` class="language-` + html.EscapeString(n.lang) + `"`
+	}
+	fmt.Fprintf(b, "<pre><code%s>%s</code></pre>\n", class, html.EscapeString(strings.TrimSuffix(string(n.text), "\n")))
+}
+
+// renderParagraph renders the lines of a paragraph block, which may also be a
+// table, a quote, a thematic break or a line of allowed HTML.
+func renderParagraph(b *strings.Builder, text string) {
+	lines := strings.Split(text, "\n")
+	switch {
+	case isThematicBreak(text):
+		b.WriteString("<hr>\n")
+	case isTable(lines):
+		renderTable(b, lines)
+	case strings.HasPrefix(text, ">"):
+		for i, l := range lines {
+			l = strings.TrimPrefix(l, ">")
+			lines[i] = strings.TrimPrefix(l, " ")
+		}
+		b.WriteString("<blockquote>\n")
+		renderBlocks(b, parseBlocks(lines))
+		b.WriteString("</blockquote>\n")
+	case isHTMLBlock(text):
+		// e.g. the <details> of nested toggles, which must not end up in a
+		// <p>.
+		b.WriteString(renderInline(text) + "\n")
+	default:
+		for i, l := range lines {
+			lines[i] = renderInline(l)
+		}
+		b.WriteString("<p>" + strings.Join(lines, "<br>") + "</p>\n")
+	}
+}
+
+func isThematicBreak(text string) bool {
+	t := strings.ReplaceAll(text, " ", "")
+	return len(t) >= 3 && (strings.Trim(t, "-") == "" || strings.Trim(t, "*") == "" || strings.Trim(t, "_") == "")
+}
+
+var tableDelimiterExp = regexp.MustCompile(`^\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?$`)
+
+// isTable reports whether lines are a table with a header row and a
+// delimiter row.
+func isTable(lines []string) bool {
+	return len(lines) >= 2 && strings.Contains(lines[0], "|") && tableDelimiterExp.MatchString(strings.TrimSpace(lines[1]))
+}
+
+func renderTable(b *strings.Builder, lines []string) {
+	b.WriteString("<table>\n")
+	for i, l := range lines {
+		if i == 1 {
+			continue
+		}
+		cell := "td"
+		if i == 0 {
+			cell = "th"
+		}
+		b.WriteString("<tr>")
+		for _, c := range tableCells(l) {
+			b.WriteString("<" + cell + ">" + renderInline(c) + "</" + cell + ">")
+		}
+		b.WriteString("</tr>\n")
+	}
+	b.WriteString("</table>\n")
+}
+
+func tableCells(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	line = strings.TrimSuffix(line, "|")
+	cells := strings.Split(line, "|")
+	for i := range cells {
+		cells[i] = strings.TrimSpace(cells[i])
+	}
+	return cells
+}
+
+// allowedTags may be written as raw HTML in Markdown.
+var allowedTags = map[string]bool{
+	"img": true, "br": true, "details": true, "summary": true,
+	"sub": true, "sup": true, "u": true, "mark": true,
+}
+
+var rawTagExp = regexp.MustCompile(`^</?([a-zA-Z][a-zA-Z0-9]*)(\s+[a-zA-Z-]+(="[^"<>]*")?)*\s*/?>`)
+
+// allowedTag returns the length of the allowed HTML tag s starts with, or 0.
+func allowedTag(s string) int {
+	m := rawTagExp.FindStringSubmatch(s)
+	if m == nil || !allowedTags[strings.ToLower(m[1])] {
+		return 0
+	}
+	attrs := strings.ToLower(m[0])
+	if strings.Contains(attrs, " on") || strings.Contains(attrs, "javascript:") {
+		return 0
+	}
+	return len(m[0])
+}
+
+// isHTMLBlock reports whether text starts with an allowed block level tag.
+func isHTMLBlock(text string) bool {
+	for _, tag := range []string{"<details", "</details", "<summary"} {
+		if strings.HasPrefix(text, tag) && allowedTag(text) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// renderInline renders the inline Markdown of a single line: code spans,
+// emphasis, strikethrough, links and images. Everything else is escaped.
+func renderInline(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte("\\`*_{}[]()#+-.!|~<>$", s[i+1]) >= 0:
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+		case c == '`':
+			if n, code := codeSpan(s[i:]); n > 0 {
+				b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i += n
+				continue
+			}
+		case c == '<':
+			if n := allowedTag(s[i:]); n > 0 {
+				b.WriteString(s[i : i+n])
+				i += n
+				continue
+			}
+		case c == '!' && strings.HasPrefix(s[i+1:], "["):
+			if n, alt, url := link(s[i+1:]); n > 0 {
+				fmt.Fprintf(&b, `<img src="%s" alt="%s">`, html.EscapeString(url), html.EscapeString(alt))
+				i += n + 1
+				continue
+			}
+		case c == '[':
+			if n, text, url := link(s[i:]); n > 0 {
+				fmt.Fprintf(&b, `<a href="%s">%s</a>`, html.EscapeString(url), renderInline(text))
+				i += n
+				continue
+			}
+		case c == '*' || c == '_' || c == '~':
+			if n, em := emphasis(s, i); n > 0 {
+				b.WriteString(em)
+				i += n
+				continue
+			}
+		}
+		b.WriteString(html.EscapeString(s[i : i+1]))
+		i++
+	}
+	return b.String()
+}
+
+// plainInline returns the text of the inline Markdown s without its
+// formatting, e.g. "**Graphs** and [trees](x.md)" is "Graphs and trees".
+func plainInline(s string) string {
+	return html.UnescapeString(stripHTML(renderInline(s)))
+}
+
+// codeSpan returns the length and the content of the code span s starts with.
+func codeSpan(s string) (int, string) {
+	ticks := len(s) - len(strings.TrimLeft(s, "`"))
+	end := strings.Index(s[ticks:], s[:ticks])
+	if end < 0 {
+		return 0, ""
+	}
+	code := s[ticks : ticks+end]
+	if t := strings.TrimSpace(code); t != "" {
+		code = t
+	}
+	return 2*ticks + end, code
+}
+
+// link returns the length, text and url of the link "[text](url)" s starts
+// with. Links to scripts are not links.
+func link(s string) (int, string, string) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth > 0 {
+				continue
+			}
+			if !strings.HasPrefix(s[i+1:], "(") {
+				return 0, "", ""
+			}
+			end := strings.IndexByte(s[i+2:], ')')
+			if end < 0 {
+				return 0, "", ""
+			}
+			url := strings.TrimSpace(s[i+2 : i+2+end])
+			if strings.HasPrefix(strings.ToLower(url), "javascript:") {
+				return 0, "", ""
+			}
+			return i + 3 + end, s[1:i], url
+		}
+	}
+	return 0, "", ""
+}
+
+var emphasisTags = map[string]string{
+	"**": "strong", "__": "strong", "*": "em", "_": "em", "~~": "del", "~": "del",
+}
+
+// emphasis renders the emphasis starting at s[i] and returns its length in s.
+func emphasis(s string, i int) (int, string) {
+	delim := s[i : i+1]
+	if strings.HasPrefix(s[i:], delim+delim) {
+		delim += delim
+	}
+	tag, ok := emphasisTags[delim]
+	if !ok {
+		return 0, ""
+	}
+	start := i + len(delim)
+	if start >= len(s) || s[start] == ' ' {
+		return 0, ""
+	}
+	// snake_case is no emphasis.
+	if delim[0] == '_' && i > 0 && isWordByte(s[i-1]) {
+		return 0, ""
+	}
+	for j := start + 1; j+len(delim) <= len(s); j++ {
+		if s[j:j+len(delim)] != delim || s[j-1] == ' ' {
+			continue
+		}
+		if len(delim) == 1 && j+1 < len(s) && s[j+1] == delim[0] {
+			// the start of a double delimiter inside.
+			j++
+			continue
+		}
+		if delim[0] == '_' && j+len(delim) < len(s) && isWordByte(s[j+len(delim)]) {
+			continue
+		}
+		return j + len(delim) - i, "<" + tag + ">" + renderInline(s[start:j]) + "</" + tag + ">"
+	}
+	return 0, ""
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
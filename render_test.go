@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name, md, want string
+	}{
+		{"emphasis", "The **average** *information* of ~~all~~", "<p>The <strong>average</strong> <em>information</em> of <del>all</del></p>"},
+		{"snake case", "a_b_c", "<p>a_b_c</p>"},
+		{"math", "$a*b*c$ and $$x_1 < x_2$$", "<p>$a*b*c$ and $$x_1 &lt; x_2$$</p>"},
+		{"mathjax", `\(a_1 * b_1\)`, `<p>\(a_1 * b_1\)</p>`},
+		{"list", "- one\n- `two`\n\n1. three", "<ul>\n<li>one</li>\n<li><code>two</code></li>\n</ul>\n<ol>\n<li>three</li>\n</ol>"},
+		{"code", "```go\nif a < b {}\n```", `<pre><code class="language-go">if a &lt; b {}</code></pre>`},
+		{"table", "| a | b |\n|---|---|\n| 1 | **2** |", "<table>\n<tr><th>a</th><th>b</th></tr>\n<tr><td>1</td><td><strong>2</strong></td></tr>\n</table>"},
+		{"link", "[Anki](https://apps.ankiweb.net) [x](javascript:alert(1))", `<p><a href="https://apps.ankiweb.net">Anki</a> [x](javascript:alert(1))</p>`},
+		{"media", `<img src="Page abc_img.png"> <script>x</script>`, `<p><img src="Page abc_img.png"> &lt;script&gt;x&lt;/script&gt;</p>`},
+		{"unsafe attribute", `<img src="a" onerror="x">`, `<p>&lt;img src=&#34;a&#34; onerror=&#34;x&#34;&gt;</p>`},
+		{"lines", "a\nb", "<p>a<br>b</p>"},
+		{"quote", "> quoted", "<blockquote>\n<p>quoted</p>\n</blockquote>"},
+	}
+	defer func(name string) { codeThemeName = name }(codeThemeName)
+	codeThemeName = "none"
+	for _, tt := range tests {
+		if got := string(renderMarkdown([]byte(tt.md))); got != tt.want {
+			t.Errorf("%s: renderMarkdown(%q) =\n%q, want\n%q", tt.name, tt.md, got, tt.want)
+		}
+	}
+}
+
+func TestHighlightCode(t *testing.T) {
+	theme := codeThemes["github"]
+	got := highlightCode("# if\nif x == \"a\\\"b\": return 1.5", "Python", theme)
+	want := `<pre style="background:#f6f8fa;color:#24292e;padding:8px;border-radius:4px;text-align:left;overflow-x:auto"><code>` +
+		`<span style="color:#6a737d"># if</span>` + "\n" +
+		`<span style="color:#d73a49">if</span> x == <span style="color:#032f62">&#34;a\&#34;b&#34;</span>: ` +
+		`<span style="color:#d73a49">return</span> <span style="color:#005cc5">1.5</span></code></pre>`
+	if got != want {
+		t.Errorf("highlightCode =\n%s\nwant\n%s", got, want)
+	}
+	codeThemeName = "monokai"
+	defer func() { codeThemeName = "github" }()
+	if got := string(renderMarkdown([]byte("```sh\necho $HOME $PATH\n```"))); !strings.Contains(got, "</span> $HOME $PATH") {
+		t.Errorf("math placeholders in code: %q", got)
+	}
+	if got := highlightCode("a < b", "Plain", theme); !strings.HasSuffix(got, "<code>a &lt; b</code></pre>") {
+		t.Errorf("plain text = %s", got)
+	}
+}
+
+func TestRenderFront(t *testing.T) {
+	if got := string(renderMarkdownLine([]byte("What is **entropy**?"))); got != "What is <strong>entropy</strong>?" {
+		t.Errorf("front = %q", got)
+	}
+}
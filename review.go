@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+)
+
+// A reviewSession holds the decisions of reviewers that see all cards at once,
+// the terminal UI and the web preview, instead of one after the other like
+// Prompter.
+
+type cardState int
+
+const (
+	statePending cardState = iota
+	stateAccepted
+	stateSkipped
+)
+
+func (s cardState) String() string {
+	return [...]string{"not reviewed", "accepted", "skipped"}[s]
+}
+
+type reviewItem struct {
+	card     card2
+	toggle   string // the guid of the toggle the card is made of.
+	state    cardState
+	problems []string
+}
+
+type reviewSession struct {
+	all    []*reviewItem // the cards in the order they are written.
+	view   []*reviewItem // the cards that are reviewed.
+	j      *journal
+	splits map[string]int // the number of cards split off of a toggle.
+	err    error          // the first failure to write the journal.
+}
+
+// collectReview receives all cards and applies the mutations to the ones not
+// reviewed in the journal j yet.
+func collectReview(cards <-chan card2, j *journal, mutations ...options) []card2 {
+	doMutations := yieldDoMutation(mutations...)
+	var cs []card2
+	for card := range cards {
+		if _, ok := j.reviewed(card); !ok {
+			doMutations(&card)
+		}
+		cs = append(cs, card)
+	}
+	return cs
+}
+
+// newReviewSession prepares the review of cards. Cards not selected by review
+// are accepted as they are, cards of the journal are decided already.
+func newReviewSession(cards []card2, review review, j *journal) *reviewSession {
+	s := &reviewSession{j: j, splits: map[string]int{}}
+	for _, card := range cards {
+		if cs, ok := j.reviewed(card); ok {
+			if len(cs) == 0 {
+				s.add(&reviewItem{card: card, toggle: card.guid, state: stateSkipped}, true)
+			}
+			for _, c := range cs {
+				s.add(&reviewItem{card: c, toggle: card.guid, state: stateAccepted}, true)
+			}
+			// a skipped toggle has no cards, but still the number 0.
+			s.splits[card.guid] = max(len(cs)-1, 0)
+			continue
+		}
+		it := &reviewItem{card: card, toggle: card.guid, problems: lintCard(card)}
+		show := review.edit(it.problems)
+		if !show {
+			it.state = stateAccepted
+		}
+		s.add(it, show)
+	}
+	return s
+}
+
+func (s *reviewSession) add(it *reviewItem, show bool) {
+	s.all = append(s.all, it)
+	if show {
+		s.view = append(s.view, it)
+	}
+}
+
+func (s *reviewSession) pending() int {
+	var n int
+	for _, it := range s.view {
+		if it.state == statePending {
+			n++
+		}
+	}
+	return n
+}
+
+// acceptPending accepts all cards not reviewed yet.
+func (s *reviewSession) acceptPending() {
+	for _, it := range s.view {
+		if it.state == statePending {
+			s.decide(it, stateAccepted)
+		}
+	}
+}
+
+// decide sets the state of it and records the cards of its toggle.
+func (s *reviewSession) decide(it *reviewItem, state cardState) {
+	it.state = state
+	s.record(it.toggle)
+}
+
+// record writes the accepted cards of toggle to the journal.
+func (s *reviewSession) record(toggle string) {
+	var accepted []card2
+	for _, it := range s.all {
+		if it.toggle == toggle && it.state == stateAccepted {
+			accepted = append(accepted, it.card)
+		}
+	}
+	if err := s.j.record(toggle, accepted); err != nil && s.err == nil {
+		s.err = err
+	}
+}
+
+// split adds a copy of it, which is view[i], after it. The copy becomes a card
+// of its own.
+func (s *reviewSession) split(it *reviewItem, i int) *reviewItem {
+	s.splits[it.toggle]++
+	c := &reviewItem{card: it.card, toggle: it.toggle, problems: it.problems}
+	c.card.guid = it.toggle + strconv.Itoa(s.splits[it.toggle])
+	c.card.tags = append([][]byte{}, it.card.tags...)
+	s.all = insertItem(s.all, indexItem(s.all, it)+1, c)
+	s.view = insertItem(s.view, i+1, c)
+	return c
+}
+
+func indexItem(items []*reviewItem, it *reviewItem) int {
+	for i := range items {
+		if items[i] == it {
+			return i
+		}
+	}
+	return len(items) - 1
+}
+
+func insertItem(items []*reviewItem, i int, it *reviewItem) []*reviewItem {
+	items = append(items, nil)
+	copy(items[i+1:], items[i:])
+	items[i] = it
+	return items
+}
+
+// setField changes a field of the card of it: "f" the front, "b" the back,
+// "t" the tags separated by spaces or "d" the deck.
+func (s *reviewSession) setField(it *reviewItem, field, value string) {
+	c := &it.card
+	switch field {
+	case "f":
+		c.front = []byte(strings.TrimSpace(value))
+	case "b":
+		c.back = []byte(value)
+	case "t":
+		c.tags = nil
+		for _, tag := range strings.Fields(value) {
+			c.tags = append(c.tags, []byte(tag))
+		}
+	case "d":
+		c.deck = strings.TrimSpace(value)
+	}
+	it.problems = lintCard(*c)
+	if it.state == stateAccepted {
+		s.record(it.toggle)
+	}
+}
+
+// sendAccepted sends the accepted cards in order.
+func (s *reviewSession) sendAccepted(ctx context.Context, editedCards chan<- card2) {
+	for _, it := range s.all {
+		if it.state != stateAccepted {
+			continue
+		}
+		select {
+		case editedCards <- it.card:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func joinTags(tags [][]byte) []byte {
+	var b []byte
+	for i, tag := range tags {
+		if i > 0 {
+			b = append(b, ' ')
+		}
+		b = append(b, tag...)
+	}
+	return b
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitAfterResume(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "Page.session.jsonl")
+	j, err := openJournal(fp, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	card := card2{front: []byte("front"), back: []byte("back"), guid: "g"}
+	if err := j.record(card.guid, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.close(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if j, err = openJournal(fp, true); err != nil {
+		t.Fatal(err)
+	}
+	defer j.close(false)
+	s := newReviewSession([]card2{card}, reviewAll, j)
+	if len(s.all) != 1 || s.all[0].state != stateSkipped {
+		t.Fatalf("session = %v, want the skipped card", s.all)
+	}
+	if c := s.split(s.all[0], 0); c.card.guid != "g1" {
+		t.Errorf("split off guid %q, want g1", c.card.guid)
+	}
+}
@@ -0,0 +1,527 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// A minimal writer for the SQLite 3 file format, just enough to produce the
+// collection inside of an .apkg file without a cgo dependency.
+// see https://www.sqlite.org/fileformat2.html
+//
+// The database is written in one go: tables and indexes are turned into
+// balanced b-trees, nothing can be updated afterwards. Values can be nil,
+// int, int64, float64, string and []byte.
+
+const sqlitePageSize = 4096
+
+type sqliteTable struct {
+	name string
+	// sql is the create statement as it is stored in sqlite_master.
+	sql string
+	// pk tells if the first column is an "integer primary key", which SQLite
+	// stores as the rowid instead of in the record.
+	pk      bool
+	rows    [][]interface{}
+	indexes []sqliteIndex
+}
+
+type sqliteIndex struct {
+	name string
+	sql  string
+	// cols are the indexes of the indexed columns in the table rows.
+	cols []int
+}
+
+type sqliteWriter struct {
+	pages [][]byte
+}
+
+// newPage appends an empty page and returns its 1-based page number.
+func (db *sqliteWriter) newPage() uint32 {
+	db.pages = append(db.pages, make([]byte, sqlitePageSize))
+	return uint32(len(db.pages))
+}
+
+func (db *sqliteWriter) page(n uint32) []byte {
+	return db.pages[n-1]
+}
+
+// writeSQLite writes a database containing tables to w.
+func writeSQLite(w io.Writer, tables []*sqliteTable) error {
+	db := &sqliteWriter{}
+	db.newPage() // page 1 holds the header and the sqlite_master table.
+
+	var master [][]interface{}
+	for _, t := range tables {
+		entries, err := t.entries()
+		if err != nil {
+			return fmt.Errorf("sqlite: table %s: %w", t.name, err)
+		}
+		root := db.writeTable(entries, t.pk)
+		master = append(master, []interface{}{"table", t.name, t.name, int64(root), t.sql})
+
+		for _, idx := range t.indexes {
+			root := db.writeIndex(t.indexEntries(entries, idx))
+			master = append(master, []interface{}{"index", idx.name, t.name, int64(root), idx.sql})
+		}
+	}
+
+	var cells [][]byte
+	for i, row := range master {
+		cells = append(cells, db.tableLeafCell(int64(i+1), encodeRecord(row)))
+	}
+	if !fits(100, 8, cells) {
+		return errors.New("sqlite: schema does not fit on the first page")
+	}
+	writeBtreePage(db.page(1), 100, 0x0d, cells, 0)
+	db.writeHeader()
+
+	for _, p := range db.pages {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *sqliteWriter) writeHeader() {
+	h := db.page(1)[:100]
+	copy(h, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(h[16:], sqlitePageSize)
+	h[18], h[19] = 1, 1 // legacy journal mode
+	h[21], h[22], h[23] = 64, 32, 32
+	binary.BigEndian.PutUint32(h[24:], 1)                     // file change counter
+	binary.BigEndian.PutUint32(h[28:], uint32(len(db.pages))) // size in pages
+	binary.BigEndian.PutUint32(h[40:], 1)                     // schema cookie
+	binary.BigEndian.PutUint32(h[44:], 4)                     // schema format
+	binary.BigEndian.PutUint32(h[56:], 1)                     // UTF-8
+	binary.BigEndian.PutUint32(h[92:], 1)                     // version-valid-for
+	binary.BigEndian.PutUint32(h[96:], 3045000)
+}
+
+type sqliteEntry struct {
+	rowid int64
+	row   []interface{}
+}
+
+// entries returns the rows sorted by rowid.
+func (t *sqliteTable) entries() ([]sqliteEntry, error) {
+	entries := make([]sqliteEntry, len(t.rows))
+	for i, row := range t.rows {
+		entries[i] = sqliteEntry{rowid: int64(i + 1), row: row}
+		if !t.pk {
+			continue
+		}
+		id, ok := toInt64(row[0])
+		if !ok {
+			return nil, fmt.Errorf("primary key %v is not an integer", row[0])
+		}
+		entries[i].rowid = id
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].rowid < entries[j].rowid })
+	for i := 1; i < len(entries); i++ {
+		if entries[i].rowid == entries[i-1].rowid {
+			return nil, fmt.Errorf("duplicate primary key %d", entries[i].rowid)
+		}
+	}
+	return entries, nil
+}
+
+func (t *sqliteTable) indexEntries(entries []sqliteEntry, idx sqliteIndex) [][]interface{} {
+	keys := make([][]interface{}, len(entries))
+	for i, e := range entries {
+		key := make([]interface{}, 0, len(idx.cols)+1)
+		for _, c := range idx.cols {
+			if t.pk && c == 0 {
+				key = append(key, e.rowid)
+				continue
+			}
+			key = append(key, e.row[c])
+		}
+		keys[i] = append(key, e.rowid)
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return compareRecords(keys[i], keys[j]) < 0 })
+	return keys
+}
+
+// writeTable writes a table b-tree and returns the root page.
+func (db *sqliteWriter) writeTable(entries []sqliteEntry, pk bool) uint32 {
+	var (
+		children []uint32
+		maxKeys  []int64 // largest rowid in children[i]
+		cells    [][]byte
+		last     int64
+	)
+	flush := func() {
+		n := db.newPage()
+		writeBtreePage(db.page(n), 0, 0x0d, cells, 0)
+		children = append(children, n)
+		maxKeys = append(maxKeys, last)
+		cells = nil
+	}
+	for _, e := range entries {
+		row := e.row
+		if pk {
+			// the integer primary key is an alias of the rowid and stored as NULL.
+			row = append([]interface{}{nil}, row[1:]...)
+		}
+		cell := db.tableLeafCell(e.rowid, encodeRecord(row))
+		if len(cells) > 0 && !fits(0, 8, append(cells, cell)) {
+			flush()
+		}
+		cells = append(cells, cell)
+		last = e.rowid
+	}
+	if len(cells) > 0 || len(children) == 0 {
+		flush()
+	}
+
+	for len(children) > 1 {
+		// every cell is a 4 byte page number and a varint of at most 9 bytes.
+		per := (sqlitePageSize-12)/(2+4+9) + 1
+		var nextChildren []uint32
+		var nextKeys []int64
+		for _, g := range balance(len(children), per) {
+			n := db.newPage()
+			var cells [][]byte
+			for i := g[0]; i < g[1]-1; i++ {
+				cell := binary.BigEndian.AppendUint32(nil, children[i])
+				cells = append(cells, appendVarint(cell, uint64(maxKeys[i])))
+			}
+			writeBtreePage(db.page(n), 0, 0x05, cells, children[g[1]-1])
+			nextChildren = append(nextChildren, n)
+			nextKeys = append(nextKeys, maxKeys[g[1]-1])
+		}
+		children, maxKeys = nextChildren, nextKeys
+	}
+	return children[0]
+}
+
+// writeIndex writes an index b-tree of the sorted keys and returns the root
+// page.
+func (db *sqliteWriter) writeIndex(keys [][]interface{}) uint32 {
+	var (
+		children []uint32
+		seps     [][]byte // seps[i] lies between children[i] and children[i+1]
+		cells    [][]byte
+	)
+	flush := func() {
+		n := db.newPage()
+		writeBtreePage(db.page(n), 0, 0x0a, cells, 0)
+		children = append(children, n)
+		cells = nil
+	}
+	size := 8
+	for i := 0; i < len(keys); i++ {
+		payload := encodeRecord(keys[i])
+		cellSize := 2 + indexCellSize(0, payload)
+		if len(cells) == 0 || size+cellSize <= sqlitePageSize {
+			cells = append(cells, db.indexCell(nil, payload))
+			size += cellSize
+			continue
+		}
+		flush()
+		size = 8
+		if i == len(keys)-1 {
+			// a separator needs a right sibling.
+			cells = append(cells, db.indexCell(nil, payload))
+			break
+		}
+		seps = append(seps, payload)
+	}
+	if len(cells) > 0 || len(children) == 0 {
+		flush()
+	}
+
+	for len(children) > 1 {
+		var largest int
+		for _, s := range seps {
+			if l := indexCellSize(4, s); l > largest {
+				largest = l
+			}
+		}
+		per := (sqlitePageSize-12)/(2+largest) + 1
+		var nextChildren []uint32
+		var nextSeps [][]byte
+		for _, g := range balance(len(children), per) {
+			n := db.newPage()
+			var cells [][]byte
+			for i := g[0]; i < g[1]-1; i++ {
+				ptr := binary.BigEndian.AppendUint32(nil, children[i])
+				cells = append(cells, db.indexCell(ptr, seps[i]))
+			}
+			writeBtreePage(db.page(n), 0, 0x02, cells, children[g[1]-1])
+			nextChildren = append(nextChildren, n)
+			if g[1]-1 < len(seps) {
+				nextSeps = append(nextSeps, seps[g[1]-1])
+			}
+		}
+		children, seps = nextChildren, nextSeps
+	}
+	return children[0]
+}
+
+// balance splits n children into groups of at most per children, such that
+// every group has at least two children and the tree stays balanced.
+func balance(n, per int) [][2]int {
+	groups := (n + per - 1) / per
+	var gs [][2]int
+	start := 0
+	for g := 0; g < groups; g++ {
+		size := n / groups
+		if g < n%groups {
+			size++
+		}
+		gs = append(gs, [2]int{start, start + size})
+		start += size
+	}
+	return gs
+}
+
+func (db *sqliteWriter) tableLeafCell(rowid int64, payload []byte) []byte {
+	cell := appendVarint(nil, uint64(len(payload)))
+	cell = appendVarint(cell, uint64(rowid))
+	return db.appendPayload(cell, payload, tableMaxLocal)
+}
+
+func (db *sqliteWriter) indexCell(prefix, payload []byte) []byte {
+	cell := appendVarint(prefix, uint64(len(payload)))
+	return db.appendPayload(cell, payload, indexMaxLocal)
+}
+
+const (
+	tableMaxLocal = sqlitePageSize - 35
+	indexMaxLocal = (sqlitePageSize-12)*64/255 - 23
+	minLocal      = (sqlitePageSize-12)*32/255 - 23
+)
+
+// indexCellSize returns the size of an index cell with a prefix of n bytes
+// without allocating overflow pages.
+func indexCellSize(n int, payload []byte) int {
+	n += len(appendVarint(nil, uint64(len(payload))))
+	if len(payload) <= indexMaxLocal {
+		return n + len(payload)
+	}
+	return n + localPayload(len(payload), indexMaxLocal) + 4
+}
+
+// localPayload returns how many bytes of an overflowing payload of size n are
+// stored on the b-tree page.
+func localPayload(n, maxLocal int) int {
+	local := minLocal + (n-minLocal)%(sqlitePageSize-4)
+	if local > maxLocal {
+		local = minLocal
+	}
+	return local
+}
+
+// appendPayload appends the part of payload stored on the b-tree page to cell
+// and spills the rest to overflow pages.
+func (db *sqliteWriter) appendPayload(cell, payload []byte, maxLocal int) []byte {
+	if len(payload) <= maxLocal {
+		return append(cell, payload...)
+	}
+	local := localPayload(len(payload), maxLocal)
+	cell = append(cell, payload[:local]...)
+
+	rest := payload[local:]
+	first := db.newPage()
+	for n := first; ; {
+		p := db.page(n)
+		k := copy(p[4:], rest)
+		rest = rest[k:]
+		if len(rest) == 0 {
+			break
+		}
+		next := db.newPage()
+		binary.BigEndian.PutUint32(p, next)
+		n = next
+	}
+	return binary.BigEndian.AppendUint32(cell, first)
+}
+
+func btreeHeaderSize(leafHeader int, typ byte) int {
+	if typ == 0x02 || typ == 0x05 {
+		return 12
+	}
+	return leafHeader
+}
+
+// fits reports whether cells fit on a page whose b-tree starts at off.
+func fits(off, header int, cells [][]byte) bool {
+	size := off + header
+	for _, c := range cells {
+		size += 2 + len(c)
+	}
+	return size <= sqlitePageSize
+}
+
+func writeBtreePage(p []byte, off int, typ byte, cells [][]byte, right uint32) {
+	hdr := btreeHeaderSize(8, typ)
+	p[off] = typ
+	binary.BigEndian.PutUint16(p[off+3:], uint16(len(cells)))
+	if hdr == 12 {
+		binary.BigEndian.PutUint32(p[off+8:], right)
+	}
+	content := sqlitePageSize
+	for i, c := range cells {
+		content -= len(c)
+		copy(p[content:], c)
+		binary.BigEndian.PutUint16(p[off+hdr+2*i:], uint16(content))
+	}
+	binary.BigEndian.PutUint16(p[off+5:], uint16(content))
+}
+
+func encodeRecord(row []interface{}) []byte {
+	var header, body []byte
+	for _, v := range row {
+		switch v := v.(type) {
+		case nil:
+			header = appendVarint(header, 0)
+		case string:
+			header = appendVarint(header, uint64(2*len(v)+13))
+			body = append(body, v...)
+		case []byte:
+			header = appendVarint(header, uint64(2*len(v)+12))
+			body = append(body, v...)
+		case float64:
+			header = appendVarint(header, 7)
+			body = binary.BigEndian.AppendUint64(body, math.Float64bits(v))
+		default:
+			i, ok := toInt64(v)
+			if !ok {
+				panic(fmt.Sprintf("sqlite: unsupported value %T", v))
+			}
+			t, size := intSerialType(i)
+			header = appendVarint(header, t)
+			for k := size - 1; k >= 0; k-- {
+				body = append(body, byte(i>>(8*k)))
+			}
+		}
+	}
+	// the header size includes its own varint.
+	n := len(header) + 1
+	if len(appendVarint(nil, uint64(n))) > 1 {
+		n++
+	}
+	rec := appendVarint(nil, uint64(n))
+	rec = append(rec, header...)
+	return append(rec, body...)
+}
+
+func intSerialType(i int64) (t uint64, size int) {
+	switch {
+	case i == 0:
+		return 8, 0
+	case i == 1:
+		return 9, 0
+	case -1<<7 <= i && i < 1<<7:
+		return 1, 1
+	case -1<<15 <= i && i < 1<<15:
+		return 2, 2
+	case -1<<23 <= i && i < 1<<23:
+		return 3, 3
+	case -1<<31 <= i && i < 1<<31:
+		return 4, 4
+	case -1<<47 <= i && i < 1<<47:
+		return 5, 6
+	default:
+		return 6, 8
+	}
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// compareRecords orders records like SQLite's BINARY collation:
+// NULL < numbers < text < blobs.
+func compareRecords(a, b []interface{}) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := compareValues(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+func compareValues(a, b interface{}) int {
+	rank := func(v interface{}) int {
+		switch v.(type) {
+		case nil:
+			return 0
+		case string:
+			return 2
+		case []byte:
+			return 3
+		default:
+			return 1
+		}
+	}
+	if ra, rb := rank(a), rank(b); ra != rb {
+		return ra - rb
+	}
+	switch a := a.(type) {
+	case nil:
+		return 0
+	case string:
+		return bytes.Compare([]byte(a), []byte(b.(string)))
+	case []byte:
+		return bytes.Compare(a, b.([]byte))
+	}
+	fa, fb := toFloat64(a), toFloat64(b)
+	switch {
+	case fa < fb:
+		return -1
+	case fa > fb:
+		return 1
+	}
+	return 0
+}
+
+func toFloat64(v interface{}) float64 {
+	if f, ok := v.(float64); ok {
+		return f
+	}
+	i, _ := toInt64(v)
+	return float64(i)
+}
+
+// appendVarint appends v in SQLite's big-endian variable length encoding.
+func appendVarint(dst []byte, v uint64) []byte {
+	if v > 0x00ffffffffffffff {
+		var buf [9]byte
+		buf[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			buf[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return append(dst, buf[:]...)
+	}
+	var buf [8]byte
+	i := len(buf) - 1
+	buf[i] = byte(v & 0x7f)
+	for v >>= 7; v > 0; v >>= 7 {
+		i--
+		buf[i] = byte(v&0x7f) | 0x80
+	}
+	return append(dst, buf[i:]...)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The export state remembers the toggles of the last runs on an export, so
+// the next run only reviews and writes the toggles which are new or changed
+// in Notion since then. It is kept in {page_name}.state.json:
+/*
+{"toggles": {"Xy3...": {"hash": "...", "notes": [
+	{"guid": "Xy3...", "hash": "...", "exported": "2024-05-01T10:00:00Z", "noteId": 1714557600000}
+]}}}
+*/
+// The hash of a toggle is taken before the review, the hashes of its notes as
+// they are written. A toggle skipped in the review has no notes. The note id
+// is only known for notes added through AnkiConnect.
+
+// exportAll reviews and writes all toggles, not only the new and changed ones.
+var exportAll bool
+
+type removedPolicy int
+
+const (
+	removedKeep removedPolicy = iota
+	removedDelete
+	removedSuspend
+)
+
+var removedPolicyNames = []string{"keep", "delete", "suspend"}
+
+// removedNotes is what happens to the notes of toggles removed from Notion.
+var removedNotes = removedKeep
+
+func (p removedPolicy) String() string { return removedPolicyNames[p] }
+
+// Set implements flag.Value.
+func (p *removedPolicy) Set(s string) error {
+	for i, name := range removedPolicyNames {
+		if s == name {
+			*p = removedPolicy(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown policy %q, use one of %v", s, removedPolicyNames)
+}
+
+type toggleChange int
+
+const (
+	toggleNew toggleChange = iota
+	toggleChanged
+	toggleUnchanged
+)
+
+type stateToggle struct {
+	Hash  string      `json:"hash"`
+	Notes []stateNote `json:"notes,omitempty"`
+}
+
+type stateNote struct {
+	GUID     string    `json:"guid"`
+	Hash     string    `json:"hash"`
+	Exported time.Time `json:"exported"`
+	NoteID   int64     `json:"noteId,omitempty"`
+}
+
+// exportState is the state of an export. A nil state remembers nothing, all
+// toggles are new.
+type exportState struct {
+	mu sync.Mutex
+	fp string
+	// toggles are the toggles of the last run, next the ones of this run
+	// which are new or changed.
+	toggles, next map[string]*stateToggle
+	seen          map[string]bool
+	counts        [3]int // by toggleChange.
+}
+
+// stateFilename returns the state of the export written for fp.
+func stateFilename(fp string) string {
+	return pageTitleToDeckName(fp) + ".state.json"
+}
+
+// openState reads the state fp. A missing file is an empty state.
+func openState(fp string) (*exportState, error) {
+	s := &exportState{fp: fp, toggles: map[string]*stateToggle{}, next: map[string]*stateToggle{}, seen: map[string]bool{}}
+	raw, err := os.ReadFile(fp)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Toggles map[string]*stateToggle `json:"toggles"`
+	}
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", fp, err)
+	}
+	if file.Toggles != nil {
+		s.toggles = file.Toggles
+	}
+	return s, nil
+}
+
+// toggleHash identifies the toggle card before the review. The options are
+// part of it, as they change the notes written for it.
+func toggleHash(c card2, mutations []options) string {
+	sum := sha1.Sum([]byte(fmt.Sprint(noteHash(c), mutations, codeThemeName)))
+	return hex.EncodeToString(sum[:])
+}
+
+// noteHash identifies the content of a note. A note with another hash than on
+// the last run has changed.
+func noteHash(c card2) string {
+	h := sha1.New()
+	for _, field := range [][]byte{c.front, c.back, joinTags(c.tags), []byte(c.deck), []byte(strconv.FormatBool(c.cloze))} {
+		h.Write(field)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// classify compares the toggle card with the last run. hash identifies its
+// content before the review.
+func (s *exportState) classify(card card2, hash string) toggleChange {
+	if s == nil {
+		return toggleNew
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seen[card.guid] = true
+	change := toggleNew
+	if last, ok := s.toggles[card.guid]; ok {
+		change = toggleChanged
+		if last.Hash == hash {
+			change = toggleUnchanged
+		}
+	}
+	s.counts[change]++
+	if change != toggleUnchanged || exportAll {
+		s.next[card.guid] = &stateToggle{Hash: hash}
+	}
+	return change
+}
+
+// written records the note card as written.
+func (s *exportState) written(card card2) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.next[card.toggle]
+	if !ok {
+		return
+	}
+	t.Notes = append(t.Notes, stateNote{GUID: card.guid, Hash: noteHash(card), Exported: time.Now().UTC(), NoteID: s.lookupNoteID(card)})
+}
+
+// noteID returns the id in Anki of the note card, 0 if it is unknown.
+func (s *exportState) noteID(card card2) int64 {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lookupNoteID(card)
+}
+
+func (s *exportState) lookupNoteID(card card2) int64 {
+	for _, toggles := range []map[string]*stateToggle{s.next, s.toggles} {
+		if t, ok := toggles[card.toggle]; ok {
+			for _, n := range t.Notes {
+				if n.GUID == card.guid && n.NoteID != 0 {
+					return n.NoteID
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// setNoteID records the id in Anki of the written note card.
+func (s *exportState) setNoteID(card card2, id int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.next[card.toggle]
+	if !ok {
+		return
+	}
+	for i := range t.Notes {
+		if t.Notes[i].GUID == card.guid {
+			t.Notes[i].NoteID = id
+		}
+	}
+}
+
+// removed returns the toggles of the last run which are gone. It is only
+// complete once all toggles are classified.
+func (s *exportState) removed() map[string]*stateToggle {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	gone := map[string]*stateToggle{}
+	for guid, t := range s.toggles {
+		if !s.seen[guid] {
+			gone[guid] = t
+		}
+	}
+	return gone
+}
+
+// removedNoteIDs returns the ids in Anki of the notes of removed toggles.
+func (s *exportState) removedNoteIDs() []int64 {
+	var ids []int64
+	for _, t := range s.removed() {
+		for _, n := range t.Notes {
+			if n.NoteID != 0 {
+				ids = append(ids, n.NoteID)
+			}
+		}
+	}
+	return ids
+}
+
+// summary tells how the toggles changed since the last run.
+func (s *exportState) summary() string {
+	return fmt.Sprintf("%d new, %d changed, %d removed, %d unchanged", s.counts[toggleNew], s.counts[toggleChanged], len(s.removed()), s.counts[toggleUnchanged])
+}
+
+// save writes the state after a successful run. Removed toggles are
+// forgotten.
+func (s *exportState) save() error {
+	s.mu.Lock()
+	file := struct {
+		Toggles map[string]*stateToggle `json:"toggles"`
+	}{map[string]*stateToggle{}}
+	for guid := range s.seen {
+		if t, ok := s.next[guid]; ok {
+			file.Toggles[guid] = t
+		} else {
+			file.Toggles[guid] = s.toggles[guid]
+		}
+	}
+	s.mu.Unlock()
+	raw, err := json.MarshalIndent(file, "", "\t")
+	if err != nil {
+		return err
+	}
+	// a crash while writing must not lose the state.
+	tmp := s.fp + ".tmp"
+	if err := os.WriteFile(tmp, append(raw, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.fp)
+}
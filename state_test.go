@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExportState(t *testing.T) {
+	chdir(t, t.TempDir())
+	fp := "Page abc.md"
+	run := func(page string) string {
+		t.Helper()
+		if err := os.WriteFile(fp, []byte(page), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := Process(context.Background(), fp, outputText, reviewNone); err != nil {
+			t.Fatal(err)
+		}
+		raw, err := os.ReadFile(MdToAnkiFilename(fp))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimPrefix(string(raw), textHeader)
+	}
+
+	if out := run("- A\n\n\ta\n- B\n\n\tb\n- C\n\n\tc\n"); strings.Count(out, "\n") != 6 {
+		t.Fatalf("first run wrote %q, want all cards", out)
+	}
+	st, err := openState(stateFilename(fp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.toggles) != 3 {
+		t.Fatalf("state has %d toggles, want 3", len(st.toggles))
+	}
+
+	// B changes, C is removed and D is new.
+	out := run("- A\n\n\ta\n- B\n\n\tchanged\n- D\n\n\td\n")
+	if strings.Contains(out, "A,") || !strings.Contains(out, "B,\"changed") || !strings.Contains(out, "D,\"d") {
+		t.Errorf("second run wrote %q, want B and D", out)
+	}
+	if st, err = openState(stateFilename(fp)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := st.toggles[noteGUID("abc", []byte("C"), 0)]; ok || len(st.toggles) != 3 {
+		t.Errorf("state keeps the removed toggle C: %v", st.toggles)
+	}
+
+	defer func() { exportAll = false }()
+	exportAll = true
+	if out := run("- A\n\n\ta\n- B\n\n\tchanged\n- D\n\n\td\n"); !strings.Contains(out, "A,") {
+		t.Errorf("run with -all wrote %q, want all cards", out)
+	}
+}
+
+func TestExportStateSummary(t *testing.T) {
+	s := &exportState{
+		toggles: map[string]*stateToggle{"a": {Hash: "1"}, "b": {Hash: "2"}, "c": {Hash: "3", Notes: []stateNote{{GUID: "c", NoteID: 7}}}},
+		next:    map[string]*stateToggle{},
+		seen:    map[string]bool{},
+	}
+	for _, c := range []struct{ guid, hash string }{{"a", "1"}, {"b", "changed"}, {"d", "4"}} {
+		s.classify(card2{guid: c.guid, toggle: c.guid}, c.hash)
+	}
+	if got, want := s.summary(), "1 new, 1 changed, 1 removed, 1 unchanged"; got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+	if ids := s.removedNoteIDs(); len(ids) != 1 || ids[0] != 7 {
+		t.Errorf("removed note ids = %v, want [7]", ids)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Tags are made from the text of headings and the fronts of parent toggles.
+// Anki ends a tag at a space, so the spaces are replaced. Quotes, commas or
+// emoji make tags which are hard to search for, they are only dropped on
+// request, as that changes the tags of existing notes:
+/*
+"Chapter 3: Graphs" 🚀  -> "Chapter_3:_Graphs"_🚀   -tag-chars all (default)
+                       -> Chapter_3_Graphs        -tag-chars letters
+                       -> chapter-3-graphs        -tag-chars letters -tag-case kebab
+*/
+// A tag map file renames headings instead, one per line. A heading mapped to
+// nothing is no tag, like the headings of -ignore-headings:
+/*
+# comment
+Chapter 3: Graphs = graphs
+Introduction =
+*/
+// Mapped tags and the tags of -add-tags are taken as they are, only their
+// spaces are replaced.
+
+type tagCharPolicy int
+
+const (
+	tagCharsAll tagCharPolicy = iota
+	tagCharsLetters
+	tagCharsASCII
+)
+
+var tagCharPolicyNames = []string{"all", "letters", "ascii"}
+
+// tagChars decides which characters of the text are kept in tags.
+var tagChars = tagCharsAll
+
+func (p tagCharPolicy) String() string { return tagCharPolicyNames[p] }
+
+// Set implements flag.Value.
+func (p *tagCharPolicy) Set(s string) error {
+	for i, name := range tagCharPolicyNames {
+		if s == name {
+			*p = tagCharPolicy(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown policy %q, use one of %v", s, tagCharPolicyNames)
+}
+
+type tagCasePolicy int
+
+const (
+	tagCaseKeep tagCasePolicy = iota
+	tagCaseLower
+	tagCaseKebab
+	tagCaseSnake
+)
+
+var tagCasePolicyNames = []string{"keep", "lower", "kebab", "snake"}
+
+// tagCase decides the case of tags and how their words are joined.
+var tagCase = tagCaseKeep
+
+func (p tagCasePolicy) String() string { return tagCasePolicyNames[p] }
+
+// Set implements flag.Value.
+func (p *tagCasePolicy) Set(s string) error {
+	for i, name := range tagCasePolicyNames {
+		if s == name {
+			*p = tagCasePolicy(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown case %q, use one of %v", s, tagCasePolicyNames)
+}
+
+// tagList is a list of tags or headings separated by commas.
+type tagList []string
+
+var (
+	// ignoredHeadings are no tags.
+	ignoredHeadings tagList
+	// staticTags are added to every card.
+	staticTags tagList
+)
+
+func (l *tagList) String() string { return strings.Join(*l, ",") }
+
+// Set implements flag.Value.
+func (l *tagList) Set(s string) error {
+	*l = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// tagMap renames the headings of the tag map file, by their lower case text.
+var tagMap map[string]string
+
+// loadTagMap reads the tag map file fp into tagMap.
+func loadTagMap(fp string) error {
+	f, err := os.Open(fp)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	m := map[string]string{}
+	s := bufio.NewScanner(f)
+	for i := 1; s.Scan(); i++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// the last "=", so headings may contain one.
+		eq := strings.LastIndex(line, "=")
+		if eq < 0 {
+			return fmt.Errorf("%s:%d: %q is no mapping, write it as heading = tag", fp, i, line)
+		}
+		heading := strings.Join(strings.Fields(line[:eq]), " ")
+		if heading == "" {
+			return fmt.Errorf("%s:%d: the heading is missing", fp, i)
+		}
+		m[strings.ToLower(heading)] = strings.TrimSpace(line[eq+1:])
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	tagMap = m
+	return nil
+}
+
+// notionIDExp matches the id Notion appends to the titles of linked pages.
+var notionIDExp = regexp.MustCompile(`\s+[0-9a-f]{32}$`)
+
+// tagName returns the tag of the plain text of a heading or front, "" if it
+// is no tag.
+func tagName(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if tagChars != tagCharsAll {
+		text = notionIDExp.ReplaceAllString(text, "")
+	}
+	for _, h := range ignoredHeadings {
+		if strings.EqualFold(h, text) {
+			return ""
+		}
+	}
+	if to, ok := tagMap[strings.ToLower(text)]; ok {
+		return strings.Join(strings.Fields(to), tagSpace)
+	}
+
+	var words []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			words = append(words, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range text {
+		switch {
+		case tagChars == tagCharsAll:
+			if unicode.IsSpace(r) {
+				flush()
+			} else {
+				word.WriteRune(r)
+			}
+		case tagChars == tagCharsASCII && r > unicode.MaxASCII:
+			// accents and emoji are dropped.
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || r == '-' || r == '_':
+			word.WriteRune(r)
+		case unicode.IsSymbol(r) || unicode.Is(unicode.Quotation_Mark, r):
+			// "don't" stays a word, emoji are dropped.
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	switch tagCase {
+	case tagCaseLower:
+		return strings.ToLower(strings.Join(words, tagSpace))
+	case tagCaseKebab:
+		return strings.ToLower(strings.Join(words, "-"))
+	case tagCaseSnake:
+		return strings.ToLower(strings.Join(words, "_"))
+	}
+	return strings.Join(words, tagSpace)
+}
+
+// cardStaticTags returns the tags added to every card.
+func cardStaticTags() [][]byte {
+	var tags [][]byte
+	for _, t := range staticTags {
+		tags = append(tags, []byte(strings.Join(strings.Fields(t), tagSpace)))
+	}
+	return tags
+}
+
+// A toggle can be tagged on its own, by hashtags at the end of its front or by
+// a last line of its back starting with "tags:":
+/*
+- What is a heap? #hard #exam
+
+	A tree with the smallest key at the root.
+	tags: hard exam
+*/
+// The tags are removed from the card and normalised like headings.
+
+// hashtagsExp matches the hashtags at the end of a front. A hashtag starts
+// with a letter, so "Issue #42" and "C#" keep their text.
+var hashtagsExp = regexp.MustCompile(`(\s+#\pL[^\s#]*)+\s*$`)
+
+// tagsLineExp matches the last line of a back holding tags, in Markdown or in
+// a paragraph of the HTML export.
+var tagsLineExp = regexp.MustCompile(`(?i)^(?:<p[^>]*>)?tags:(.*?)(?:</p>)?$`)
+
+// inlineTags removes the inline tags from the front and back of a toggle and
+// returns them.
+func inlineTags(front, back []byte, isHTML bool) ([]byte, []byte, [][]byte) {
+	var names []string
+	if m := hashtagsExp.FindIndex(front); m != nil {
+		names = strings.Fields(string(front[m[0]:m[1]]))
+		front = front[:m[0]]
+	}
+
+	text := strings.TrimRight(string(back), " \t\n")
+	last := text[strings.LastIndexByte(text, '\n')+1:]
+	if m := tagsLineExp.FindStringSubmatch(last); m != nil {
+		line := m[1]
+		if isHTML {
+			line = html.UnescapeString(stripHTML(line))
+		}
+		names = append(names, strings.FieldsFunc(line, func(r rune) bool { return unicode.IsSpace(r) || r == ',' })...)
+		if back = []byte(strings.TrimRight(text[:len(text)-len(last)], " \t\n")); len(back) > 0 {
+			back = append(back, '\n')
+		} else {
+			back = nil
+		}
+	}
+
+	var tags [][]byte
+	for _, name := range names {
+		if t := tagName(strings.TrimPrefix(name, "#")); t != "" {
+			tags = append(tags, []byte(t))
+		}
+	}
+	return front, back, tags
+}
+
+// mergeTags appends the tags of more not in tags yet. Anki ignores the case
+// of tags, so the first spelling is kept.
+func mergeTags(tags, more [][]byte) [][]byte {
+	for _, m := range more {
+		if !slices.ContainsFunc(tags, func(t []byte) bool { return bytes.EqualFold(t, m) }) {
+			tags = append(tags[:len(tags):len(tags)], m)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTagName(t *testing.T) {
+	defer func() { tagChars, tagCase = tagCharsAll, tagCaseKeep }()
+	tests := []struct {
+		chars tagCharPolicy
+		case_ tagCasePolicy
+		text  string
+		want  string
+	}{
+		{tagCharsLetters, tagCaseKeep, `"Chapter 3: Graphs" 🚀`, "Chapter_3_Graphs"},
+		{tagCharsLetters, tagCaseKeep, "Don't, stop", "Dont_stop"},
+		{tagCharsLetters, tagCaseKeep, "Graphs 0123456789abcdef0123456789abcdef", "Graphs"},
+		{tagCharsLetters, tagCaseKeep, "Café au lait", "Café_au_lait"},
+		{tagCharsASCII, tagCaseKeep, "Café au lait", "Caf_au_lait"},
+		{tagCharsAll, tagCaseKeep, "C++ & Rust", "C++_&_Rust"},
+		{tagCharsLetters, tagCaseLower, "Chapter 3: Graphs", "chapter_3_graphs"},
+		{tagCharsLetters, tagCaseKebab, "Chapter 3: Graphs", "chapter-3-graphs"},
+		{tagCharsLetters, tagCaseSnake, "Chapter 3: Graphs", "chapter_3_graphs"},
+		{tagCharsLetters, tagCaseKeep, "🚀", ""},
+	}
+	for _, tt := range tests {
+		tagChars, tagCase = tt.chars, tt.case_
+		if got := tagName(tt.text); got != tt.want {
+			t.Errorf("%v %v: tagName(%q) = %q, want %q", tt.chars, tt.case_, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTagMap(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "tags.txt")
+	if err := os.WriteFile(fp, []byte("# renames\nChapter 3: Graphs = graphs\nintroduction =\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { tagMap, ignoredHeadings, staticTags = nil, nil, nil }()
+	if err := loadTagMap(fp); err != nil {
+		t.Fatal(err)
+	}
+	ignoredHeadings = tagList{"Summary"}
+	staticTags = tagList{"exam 2024", "notion"}
+
+	page := "# Page\n\n# Chapter 3: Graphs\n\n## Introduction\n\n- a\n\n\t1\n\n## Summary\n\n- b\n\n\t2\n"
+	want := []string{"graphs exam_2024 notion", "graphs exam_2024 notion"}
+	cs := collectCards(page)
+	if len(cs) != len(want) {
+		t.Fatalf("got %d cards, want %d", len(cs), len(want))
+	}
+	for i, c := range cs {
+		if got := string(joinTags(c.tags)); got != want[i] {
+			t.Errorf("card %q has tags %q, want %q", c.front, got, want[i])
+		}
+	}
+
+	if err := os.WriteFile(fp, []byte("graphs\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadTagMap(fp); err == nil || !strings.Contains(err.Error(), "tags.txt:1: ") {
+		t.Errorf("invalid mapping: err = %v", err)
+	}
+}
+
+func TestInlineTags(t *testing.T) {
+	page := "# Page\n\n## Heaps\n\n- What is a heap? #hard #Exam\n\n\tA tree.\n\ttags: exam, proofs\n\n- Issue #42 in C#\n\n\tOpen.\n\n- Only tags\n\n\ttags: #late\n"
+	want := []struct{ front, back, tags string }{
+		{"What is a heap?", "A tree.\n", "Heaps hard Exam proofs"},
+		{"Issue #42 in C#", "Open.\n", "Heaps"},
+		{"Only tags", "", "Heaps late"},
+	}
+	cs := collectCards(page)
+	if len(cs) != len(want) {
+		t.Fatalf("got %d cards, want %d", len(cs), len(want))
+	}
+	for i, c := range cs {
+		if string(c.front) != want[i].front || string(c.back) != want[i].back || string(joinTags(c.tags)) != want[i].tags {
+			t.Errorf("card %d = %q, %q, %q, want %+v", i, c.front, c.back, joinTags(c.tags), want[i])
+		}
+	}
+	if cs[0].guid != noteGUID("abc", []byte("What is a heap?"), 0) {
+		t.Error("the hashtags changed the guid of the toggle")
+	}
+
+	front, back, tags := inlineTags([]byte("Q #a"), []byte(`<p id="1">A</p>`+"\n"+`<p id="2" class="">Tags: b &amp; c</p>`+"\n"), true)
+	if string(front) != "Q" || string(back) != "<p id=\"1\">A</p>\n" || string(joinTags(tags)) != "a b & c" {
+		t.Errorf("HTML: got %q, %q, %q", front, back, joinTags(tags))
+	}
+}
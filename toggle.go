@@ -1,18 +1,18 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/csv"
-	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
-	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -24,7 +24,7 @@ import (
 // The Title of the page will be the Anki deck title.
 
 // The front of each note will be the words of the toggle when collapsed, the content is the back.
-// Nested toggels will not be respected.
+// Nested toggles are kept, split off, rendered collapsed or dropped, see nested.go.
 
 // All headings are used as tags for the cards following that tag.
 // Subsequent headings will inherit the tags of the previous titles with the larger formatting
@@ -63,79 +63,67 @@ type tag struct {
 	// must be omitted.
 	// see https://anki.tenderapp.com/discussions/ankidesktop/28088-which-characters-can-tags-contain
 	name []byte
+	// text is the heading as it is written, for the name of a subdeck.
+	text []byte
 
 	// level is 1, 2 and 3 for the according headings in anki.
 	level int8
 }
 
-// NL is the platform agnostic new line character. (CRLF on windows, else jsut LF)
-var NL string = func() string {
-	switch runtime.GOOS {
-	case "windows":
-		return "\r\n"
-	default:
-		return "\n"
-	}
-}()
-
-const INDENT = `[\t| {4}]`
-
-var (
-	// headingExp will be called with Submatch method, idx 1 returns the heading
-	// with # and without NL.
-	/* heading matches:
-	...
-	# foo bar foo2
-	...
-	or:
-	...
-	## foo bar foo2
-	...
-	or:
-	...
-	### foo bar foo2
-	...
-	*/
-	headingExp = `(#{1,3}\s.+)` + NL
-
-	/* toggle matches:
-	....
-	- foo bar foo2
-
-		foo bar bar bar, foo
-		foo bar foo bar.
-
-	....
-	*/
-	toggleExp = "" + // capture group 0 includes hole toogle.
-		// head
-		`-\s(.+)` + NL + // capture group 1: use!
-		// body
-		NL +
-		// only last capture group will be stored, thus this cg is only ment
-		// for grouping.
-		`(` + INDENT + `.+` + NL +
-		`|` + NL + `)+` + // capture group 2: do not use, only includes last occurance.
-		NL
-)
-
 // pageTitleToDeckNames takes the filepath of the exported file and returns the
 // title of the page, which is included in the exported files filename.
 // "{name inc. spaces} {id}.md"
 func pageTitleToDeckName(fp string) string {
 	name := filepath.Base(fp)
 	i := strings.LastIndex(name, " ")
+	if i == -1 {
+		// not named by notion, e.g. a folder of pages.
+		i = len(name) - len(filepath.Ext(name))
+	}
 	return strings.ReplaceAll(name[0:i], " ", "_") // anki expects underscores.
 }
 
+// pageID returns the id Notion appends to the filename of an exported page.
+// "{name inc. spaces} {id}.md"
+func pageID(fp string) string {
+	name := strings.TrimSuffix(filepath.Base(fp), filepath.Ext(fp))
+	return name[strings.LastIndex(name, " ")+1:]
+}
+
 func MdToAnkiFilename(fp string) string {
-	return pageTitleToDeckName(fp) + ".txt"
+	return outputName(fp) + ".txt"
 }
 
 type card2 struct {
 	front []byte
 	back  []byte
 	tags  [][]byte
+
+	// guid identifies the note in Anki across exports, so re-importing an
+	// edited page updates the notes instead of duplicating them.
+	guid string
+	// toggle is the guid of the toggle the card is made of. Cards split off of
+	// a toggle in the review share it.
+	toggle string
+
+	// cloze is set for toggles marked as cloze notes, see markCloze.
+	cloze bool
+
+	// deck is the name of the deck the note is added to.
+	deck string
+
+	// html is set if front and back are HTML already, instead of the text of
+	// the Markdown export.
+	html bool
+}
+
+// noteGUID derives the guid of the n-th toggle with front on the page with id
+// pageID. n only differs from 0 if a page has several toggles with the same
+// front, thus reordering toggles or editing the back keeps the guid.
+func noteGUID(pageID string, front []byte, n int) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%d", pageID, front, n)
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:9])
 }
 
 func (c card2) String() string {
@@ -148,420 +136,568 @@ func (c card2) String() string {
 	return sb.String()
 }
 
+// output selects the format Process writes the deck in.
+type output int
+
+const (
+	// outputText is a CSV .txt file, which must be imported with "Allow HTML"
+	// ticked. The media files must be moved separately.
+	outputText output = iota
+	// outputApkg is an .apkg package including the deck and the media files.
+	outputApkg
+	// outputAnkiConnect adds the notes and media files to the running Anki
+	// through AnkiConnect.
+	outputAnkiConnect
+)
+
+// page is an exported Notion page and the deck its toggles are added to.
+type page struct {
+	fp   string
+	deck string
+}
+
+// PipelineError is returned by Process when a stage failed. The other stages
+// are cancelled and no output is written.
+type PipelineError struct {
+	Stage string // one of the stage constants
+	Err   error
+}
+
+const (
+	stageRead   = "read"
+	stageReview = "review"
+	stageWrite  = "write"
+)
+
+func (e *PipelineError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Stage, e.Err)
+}
+
+func (e *PipelineError) Unwrap() error {
+	return e.Err
+}
+
+// report sends the error of a stage to ProcessPages and waits until it has
+// cancelled the other stages, so the stages after it do not take the closing
+// of their input for a finished run.
+func report(ctx context.Context, errc chan<- error, err error) {
+	errc <- err
+	<-ctx.Done()
+}
+
 // Process is the many entry point which turns a file into an importable anki deck.
-func Process(fp string, mutations ...options) error {
-	raw, err := os.ReadFile(fp)
+func Process(ctx context.Context, fp string, out output, review review, mutations ...options) error {
+	return ProcessPages(ctx, []page{{fp: fp, deck: pageTitleToDeckName(fp)}}, fp, out, review, mutations...)
+}
+
+// ProcessPages turns several pages into one output named after fp. The pages
+// are parsed concurrently, but their cards are reviewed and written in the
+// order of pages, so the output is the same on every run.
+// The first stage to fail cancels all others, its error is returned. If ctx is
+// cancelled, ctx.Err() is returned.
+func ProcessPages(ctx context.Context, pages []page, fp string, out output, review review, mutations ...options) error {
+	raws := make([][]byte, len(pages))
+	mediaDps := make([]string, len(pages))
+	for i, p := range pages {
+		raw, err := os.ReadFile(p.fp)
+		if err != nil {
+			return &PipelineError{Stage: stageRead, Err: err}
+		}
+		raws[i] = raw
+		mediaDps[i] = mediaDir(p.fp)
+	}
+
+	st, err := openState(stateFilename(fp))
 	if err != nil {
-		return err
+		return &PipelineError{Stage: stageRead, Err: err}
 	}
+
+	var j *journal
+	if review != reviewNone {
+		if j, err = openJournal(journalFilename(fp), resumeSession); err != nil {
+			return &PipelineError{Stage: stageReview, Err: err}
+		}
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	errc := make(chan error)
-	hIdxc := make(chan [2]int) // header: start:end of file byte array excluding newline.
-	tIdxc := make(chan [4]int) // toggle: front, back: fstart:fend bstart, bend; excluding newline and indentation.
+	var firstErr error
+	collected := make(chan struct{})
+	go func() {
+		for err := range errc {
+			if firstErr == nil {
+				firstErr = err
+				cancel()
+			}
+		}
+		close(collected)
+	}()
+
+	pageCards := make([]chan card2, len(pages))
 	cards := make(chan card2)
+	changed := make(chan card2)
 	editedCards := make(chan card2)
-
-	filename := MdToAnkiFilename(fp)
+	written := make(chan card2)
 
 	var wg sync.WaitGroup
+	for i, p := range pages {
+		blocks := make(chan *node) // top level headings and toggles in document order.
+		pageCards[i] = make(chan card2, 64)
+		wg.Add(2)
+		go func(fp string, raw []byte) { findBlocks(ctx, parseDocument(fp, raw), blocks, &wg) }(p.fp, raws[i])
+		go combine(ctx, p, blocks, pageCards[i], &wg)
+	}
 	wg.Add(5)
-	go findHeadings(raw, hIdxc, &wg)
-	go findToggles(raw, tIdxc, &wg)
-	go combine(raw, hIdxc, tIdxc, cards, errc, &wg)
-	go Prompter(cards, editedCards, &wg, mutations...)
-	go Serialiser(filename, editedCards, &wg)
+	go inOrder(ctx, pageCards, cards, &wg)
+	go changedToggles(ctx, cards, changed, st, mutations, &wg)
+	switch {
+	case serveMode && review != reviewNone:
+		go WebReviewer(ctx, changed, editedCards, review, j, mediaDps, errc, &wg, mutations...)
+	case reviewTUI && review != reviewNone:
+		go TUIReviewer(ctx, changed, editedCards, review, j, errc, &wg, mutations...)
+	default:
+		go Prompter(ctx, changed, editedCards, review, j, errc, &wg, mutations...)
+	}
+	go writtenNotes(ctx, editedCards, written, st, &wg)
+	switch out {
+	case outputApkg:
+		go ApkgSerialiser(ctx, MdToApkgFilename(fp), mediaDps, written, errc, &wg)
+	case outputAnkiConnect:
+		go AnkiConnectSerialiser(ctx, ankiConnectURL, mediaDps, st, written, errc, &wg)
+	default:
+		go Serialiser(ctx, MdToAnkiFilename(fp), written, errc, &wg)
+	}
 	wg.Wait()
+	close(errc)
+	<-collected
 
-	return nil
-}
-
-// findHeading drops the first found heading, because it is the page name.
-func findHeadings(raw []byte, hc chan<- [2]int, wg *sync.WaitGroup) {
-	re := regexp.MustCompile(headingExp)
-	// res is an slice of 4 int slices. These 4 ints describe 2 sections on the
-	// raw byte array. The first section is the hole regexp match, the second
-	// only the capture group.
-	res := re.FindAllSubmatchIndex(raw, -1)
-
-	// drop first heading because its the page name
-	for _, idxs := range res[1:] {
-		// we only send the capturing group.
-		hc <- [2]int{idxs[2], idxs[3]}
-	}
-	close(hc)
-	wg.Done()
+	err = firstErr
+	if err == nil {
+		err = parent.Err()
+	}
+	if err == nil {
+		log.Printf("Toggles since the last run: %s.\n", st.summary())
+		if removed := len(st.removed()); removed > 0 && removedNotes != removedKeep && out != outputAnkiConnect {
+			log.Printf("The notes of %d removed toggles can only be removed from Anki with -anki-connect.\n", removed)
+		}
+		if serr := st.save(); serr != nil {
+			err = &PipelineError{Stage: stageWrite, Err: serr}
+		}
+	}
+	if j != nil {
+		if cerr := j.close(err == nil); cerr != nil && err == nil {
+			err = &PipelineError{Stage: stageReview, Err: cerr}
+		}
+		if err != nil && len(j.done) > 0 {
+			log.Printf("Your edits of %d cards are kept in %q, continue with -resume.\n", len(j.done), j.fp)
+		}
+	}
+	return err
 }
 
-func findToggles(raw []byte, tc chan<- [4]int, wg *sync.WaitGroup) {
-	re := regexp.MustCompile(toggleExp)
-	// res returns 3 sections per match, each described by two consecutive indexes.
-	// The zeroth section is the hole match, the first the front without dash
-	// and without newlines. The third one is the last match in the toggle body,
-	// and does NOT represent the hole body.
-	res := re.FindAllSubmatchIndex(raw, -1)
-
-	for _, idxs := range res {
-		// sections by index in idxs:
-		// full (0, 1)
-		// heading (2, 3), excluding dash and newline
-		// last body cg (4, 5)
-		// negative lookahead workaround char (6, 7)
-		hstart, hend := idxs[2], idxs[3]
-
-		// transform the full match to the body part.
-		var bstart, bend int
-		// bstart is the start of the body including identation.
-		off := idxs[0]
-		body := raw[off:idxs[1]]
-		sep := []byte(NL + NL)
-		i := bytes.Index(body, sep)
-		if i == -1 {
-			// cannot occur.... still check
-			log.Panic("shoud never happen because regexp shouldn't allow this.")
-		}
-		bstart = off + i + len(sep) // includes spaceing! remove later with ReplaceAll(body, "\n    ", "\n"), only removes at linestart
-
-		// bend is the end of the the body including one newline.
-		// use the end of the last matching body capture group, section 2
-		bend = idxs[5]
-
-		tc <- [4]int{hstart, hend, bstart, bend}
-	}
-	close(tc)
-	wg.Done()
+// parseDocument parses the page fp of the Markdown or the HTML export,
+// depending on its extension.
+func parseDocument(fp string, raw []byte) []*node {
+	if isHTMLPage(fp) {
+		return parseNotionHTML(raw)
+	}
+	return parseMarkdown(raw)
 }
 
-// tagStack will always hold the latest headings with decreasing order.
-// when a h1 and a h2 is in there and a h3 is found, add that to the end.
-// when a h2 is encountered, replace h2 and remove h3.
-// DO NOT ITERATE over tagStack without checking if the default tag values are used.
-type tagStack struct {
-	tags [3]tag // three heading levels
-	len  int
+// changedToggles forwards the toggles which are new or changed since the last
+// run recorded in st. With exportAll all toggles are forwarded.
+func changedToggles(ctx context.Context, cards <-chan card2, changed chan<- card2, st *exportState, mutations []options, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer close(changed)
+	for c := range cards {
+		if st.classify(c, toggleHash(c, mutations)) == toggleUnchanged && !exportAll {
+			continue
+		}
+		select {
+		case changed <- c:
+		case <-ctx.Done():
+			return
+		}
+	}
 }
 
-func (stk *tagStack) push(t tag) {
-	if stk.len == 0 {
-		stk.tags[0] = t
-		stk.len++
-		return
-	}
-	var i int
-	for ; i < stk.len; i++ {
-		if t.level <= stk.tags[i].level {
-			stk.tags[i] = t
-			stk.len = 1 + i
+// writtenNotes records the cards passed on to the serialiser in st.
+func writtenNotes(ctx context.Context, cards <-chan card2, written chan<- card2, st *exportState, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer close(written)
+	for c := range cards {
+		st.written(c)
+		select {
+		case written <- c:
+		case <-ctx.Done():
 			return
 		}
 	}
-	stk.tags[i] = t
-	stk.len++
 }
 
-func (stk tagStack) bytes() [][]byte {
-	var bss [][]byte
-	for i := 0; i < stk.len; i++ {
-		bss = append(bss, stk.tags[i].name)
+// inOrder forwards the cards of all pages to cards, one page after the other.
+func inOrder(ctx context.Context, pageCards []chan card2, cards chan<- card2, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer close(cards)
+	for _, pc := range pageCards {
+		for c := range pc {
+			select {
+			case cards <- c:
+			case <-ctx.Done():
+				return
+			}
+		}
 	}
-	return bss
 }
 
-func newTag(bs []byte) tag {
-	t := tag{}
-	for i, c := range bs {
-		if c == '#' {
-			t.level++
+// findBlocks sends all headings and toggles on the top level of the document
+// to bc. Blocks nested in toggles, like python comments in a code block, are
+// part of the toggle and never reach bc.
+// findBlocks drops the first heading, because it is the page name.
+func findBlocks(ctx context.Context, doc []*node, bc chan<- *node, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer close(bc)
+	title := true
+	for _, n := range doc {
+		if n.kind == nodeHeading && title {
+			title = false
+			continue
+		}
+		if n.kind != nodeHeading && !n.isToggle() {
 			continue
 		}
-		bs = bytes.Trim(bs[i:], "\t ")
-		t.name = bytes.ReplaceAll(bs, []byte{' '}, []byte{'_'})
-		return t
+		select {
+		case bc <- n:
+		case <-ctx.Done():
+			return
+		}
 	}
-	panic("invalid byte slice.")
 }
 
-// Combine should only be run once for every file and cannot be put behind a
-// load balancer.
-// Combiner takes all the input streams at channels them into a card, which will
-// then be used by the prompter.
-func combine(raw []byte, headings <-chan [2]int, toggles <-chan [4]int, cards chan<- card2, errc chan<- error, wg *sync.WaitGroup) {
-	stack := tagStack{}
-
-	// TODO(liamvdv): how could this be pipelined?
-	var hs [][2]int
-	for h := range headings {
-		hs = append(hs, h)
-	}
+// tagStack will always hold the latest headings with increasing levels.
+// when a h1 and a h2 is in there and a h3 is found, add that to the end.
+// when a h2 is encountered, replace h2 and remove h3.
+type tagStack struct {
+	tags []tag
+}
 
-	var ts [][4]int
-	for t := range toggles {
-		//fmt.Printf("Toggle Name: %q\n", string(raw[t[0]:t[1]]))
-		//fmt.Printf("Toggle Body: %q\n", strings.ReplaceAll(string(raw[t[2]:t[3]]), "    ", ""))
-		ts = append(ts, t)
+func (stk *tagStack) push(t tag) {
+	i := len(stk.tags)
+	for i > 0 && t.level <= stk.tags[i-1].level {
+		i--
 	}
+	stk.tags = append(stk.tags[:i], t)
+}
 
-	for _, t := range ts {
-		card := card2{
-			front: raw[t[0]:t[1]],
-			back:  bytes.ReplaceAll(raw[t[2]:t[3]], []byte("    "), nil), // remove indent
+// split returns the headings up to level depth as the names of subdecks and
+// the deeper ones as tags.
+func (stk tagStack) split(depth int) (decks []string, tags [][]byte) {
+	for _, t := range stk.tags {
+		if len(t.name) == 0 {
+			continue
 		}
-
-		for _, h := range hs {
-			if h[1] <= t[0] { // if the heading comes before the toggle (= because index is one greater than real end)
-				tag := newTag(raw[h[0]:h[1]])
-				stack.push(tag)
-				hs = hs[1:] // reduce the array
-				continue
-			}
-			// headings within toggles should be ignored (includes code blocks, f. e. python comments)
-			if h[1] <= t[3] {
-				hs = hs[1:]
-				continue
-			}
-			// headings comes after card, do not consume yet
-			break
+		if int(t.level) <= depth {
+			decks = append(decks, deckName(string(t.text)))
+			continue
 		}
-		card.tags = stack.bytes()
-		cards <- card
+		tags = append(tags, t.name)
 	}
-	close(cards)
-	wg.Done()
+	return decks, tags
 }
 
-var (
-	subsep   []byte = bytes.Repeat([]byte{'~'}, 5)
-	frontSep []byte
-	backSep  []byte
-	tagsSep  []byte
-)
-
-func init() {
-	var (
-		front = []byte("Front")
-		back  = []byte("Back")
-		tags  = []byte("Tags")
-		nl    = []byte{'\n'}
-	)
-	fill := func(dst []byte, srcs ...[]byte) int {
-		for i := range srcs {
-			dst = append(dst, srcs[i]...)
-		}
-		return len(dst)
+// deckName returns the text of a heading as the name of a single deck, as
+// Anki starts a subdeck at "::".
+func deckName(text string) string {
+	for strings.Contains(text, "::") {
+		text = strings.ReplaceAll(text, "::", ":")
 	}
-
-	frontSep = make([]byte, 0, 2*len(subsep)+len(front)+1)
-	n := fill(frontSep, subsep, front, subsep, nl)
-	frontSep = frontSep[0:n]
-
-	backSep = make([]byte, 0, 2*len(subsep)+len(back)+1)
-	n = fill(backSep, subsep, back, subsep, nl)
-	backSep = backSep[0:n]
-
-	tagsSep = make([]byte, 0, 2*len(subsep)+len(tags)+1)
-	n = fill(tagsSep, subsep, tags, subsep, nl)
-	tagsSep = tagsSep[0:n]
+	return text
 }
 
-func Prompter(cards <-chan card2, editedCards chan<- card2, wg *sync.WaitGroup, mutations ...options) {
-	name := "note.txt"
-	dp, err := os.Getwd()
-	if err != nil {
-		log.Panic(err)
-	}
-	fp := filepath.Join(dp, name)
-	defer os.Remove(fp)  // omit error, possible because card doesn't run
+// Tags are taken from the headings above a toggle:
+/*
+# ABC
+## b
+- toggle
+*/
+// headings:     the toggle is tagged ABC and b.
+// hierarchical: the toggle is tagged Page::ABC::b, which Anki shows as a tree
+//               below the page.
+// none:         the toggle has no tags.
+type tagPolicy int
 
-	doMutations := yieldDoMutation(mutations...)
+const (
+	tagsHeadings tagPolicy = iota
+	tagsHierarchical
+	tagsNone
+)
 
-	cmd := getCmd(fp)
-	exe := exec.Command(cmd[0], cmd[1:]...)
-	for card := range cards {
-		// apply patches such as converting to mathjax format.
-		doMutations(&card)
+var tagPolicyNames = []string{"headings", "hierarchical", "none"}
 
-		if err := createPrompt(fp, &card); err != nil {
-			log.Panic(err)
-		}
+// tagStrategy decides how the cards are tagged.
+var tagStrategy = tagsHeadings
 
-		exec := *exe
-		runCommand(&exec)
+func (p tagPolicy) String() string { return tagPolicyNames[p] }
 
-		cs, err := readPrompt(fp)
-		if err != nil {
-			if err == skipNote {
-				continue
-			}
-			log.Panic(err)
-		}
-		for _, card := range cs {
-			editedCards <- card
+// Set implements flag.Value.
+func (p *tagPolicy) Set(s string) error {
+	for i, name := range tagPolicyNames {
+		if s == name {
+			*p = tagPolicy(i)
+			return nil
 		}
 	}
-	close(editedCards)
-	wg.Done()
+	return fmt.Errorf("unknown strategy %q, use one of %v", s, tagPolicyNames)
 }
 
-// does not yet support vim, vim needs terminal emulated (shell)
-var (
-	windowsEditor = "notepad.exe"
-
-	linuxShell  = []string{"bash", "-c"}
-	linuxEditor = "nano"
-)
-
-// return a string array which can be passed to exec.Command([0], [1:]...)
-func getCmd(fp string) (cmd []string) {
-	switch runtime.GOOS {
-	case "windows":
-		cmdx, err := exec.LookPath(windowsEditor)
-		if err != nil {
-			log.Panic(err)
-		}
-		cmd = []string{cmdx, fp}
-	case "linux", "darwin":
-		if editor := os.Getenv("EDITOR"); editor != "" {
-			if editor == "vi" || editor == "vim" {
-				fmt.Printf("%s does not currently support vim, because vim requires an emulated terminal.", NAME)
-			} else {
-				linuxEditor = editor
+// headingDepth is the deepest heading level used as a tag.
+var headingDepth = 3
+
+// subdeckDepth is the deepest heading level which becomes a subdeck of the
+// page instead of a tag, 0 keeps all headings as tags.
+var subdeckDepth int
+
+// tagSpace replaces the spaces of headings, which Anki takes as the end of a
+// tag.
+var tagSpace = "_"
+
+// cardTags returns the tags of a card in deck below the path of headings and
+// parent toggles, and the tags added to every card.
+func cardTags(deck string, path [][]byte) [][]byte {
+	switch tagStrategy {
+	case tagsHierarchical:
+		// the decks are normalised like headings, a space would split the
+		// tag.
+		var root [][]byte
+		for _, d := range strings.Split(deck, "::") {
+			if name := tagName(d); name != "" {
+				root = append(root, []byte(name))
 			}
 		}
-		cmd = append(linuxShell, linuxEditor+" "+fp)
-	default:
-		panic("unknown platform.")
+		path = [][]byte{bytes.Join(append(root, path...), []byte("::"))}
+	case tagsNone:
+		path = nil
 	}
-	return
+	return append(path[:len(path):len(path)], cardStaticTags()...)
 }
 
-// createPrompt serialises the card and seperates all fields with 10 tildes (~~~~~)
-func createPrompt(fp string, card *card2) error {
-	ufile, err := os.OpenFile(fp, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0755)
-	if err != nil {
-		return err
-	}
-	defer saveClose(ufile)
-	file := bufio.NewWriter(ufile)
-
-	file.Write(frontSep)
-	file.Write(card.front)
-	file.WriteRune('\n')
-	file.Write(backSep)
-	file.Write(card.back)
-	file.Write(tagsSep)
-	for i := range card.tags {
-		file.Write(card.tags[i])
-		file.WriteRune('\n')
-	}
-	return file.Flush()
+// newTag returns the tag of heading. The inline Markdown of a heading, like
+// emphasis or links, is left out of the tag, and an empty heading has no tag.
+func newTag(heading *node, isHTML bool) tag {
+	text := string(heading.text)
+	if !isHTML {
+		text = plainInline(text)
+	}
+	text = strings.Join(strings.Fields(text), " ")
+	return tag{
+		name:  []byte(tagName(text)),
+		text:  []byte(text),
+		level: int8(heading.level),
+	}
 }
 
-var skipLit = []byte("skip")
-var skipNote = errors.New("Skip this note.")
-
-// readPrompt reads in the user modified file. It also handles skiping notes
-// with the skipNote error. The user may include mutliple notes in one file.
-// If the file is empty or begins with "skip", readPrompt returns the skipNote error.
-func readPrompt(fp string) ([]card2, error) {
-	raw, err := os.ReadFile(fp)
-	if err != nil {
-		return nil, err
+// Combine should only be run once for every file and cannot be put behind a
+// load balancer.
+// Combiner takes the stream of blocks and turns every toggle into a card
+// tagged with the headings above it and its inline tags, which will then be
+// used by the prompter.
+func combine(ctx context.Context, p page, blocks <-chan *node, cards chan<- card2, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer close(cards)
+	id := pageID(p.fp)
+	stack := tagStack{}
+	seen := map[string]int{} // number of toggles with the same front so far.
+	isHTML := isHTMLPage(p.fp)
+
+	var emit func(t *node, deck string, tags [][]byte)
+	emit = func(t *node, deck string, tags [][]byte) {
+		back, nested := toggleBack(t, nestedToggles, isHTML)
+		front, back, inline := inlineTags(t.text, back, isHTML)
+		n := seen[string(front)]
+		seen[string(front)]++
+		guid := noteGUID(id, front, n)
+		select {
+		case cards <- card2{
+			front:  front,
+			back:   back,
+			tags:   mergeTags(cardTags(deck, tags), inline),
+			guid:   guid,
+			toggle: guid,
+			deck:   deck,
+			html:   isHTML,
+		}:
+		case <-ctx.Done():
+			return
+		}
+		// nested cards are tagged with the front of their parent.
+		for _, c := range nested {
+			nestedTags := tags[:len(tags):len(tags)]
+			if tag := frontTag(front, isHTML); len(tag) > 0 {
+				nestedTags = append(nestedTags, tag)
+			}
+			emit(c, deck, nestedTags)
+		}
 	}
 
-	// check if file should be skipped explicitly:
-	if len(raw) < len(skipLit) {
-		return nil, skipNote
-	}
-	if bytes.EqualFold(raw[0:len(skipLit)], skipLit) {
-		return nil, skipNote
+	for b := range blocks {
+		if b.kind == nodeHeading {
+			if b.level > headingDepth {
+				// headings below headingDepth are no tags.
+				continue
+			}
+			stack.push(newTag(b, isHTML))
+			continue
+		}
+		decks, tags := stack.split(subdeckDepth)
+		emit(b, strings.Join(append([]string{p.deck}, decks...), "::"), tags)
 	}
+}
 
-	// Parse the file
-	pattern := string(frontSep) +
-		`(.*\s)+` +
-		string(backSep) +
-		`(.*\s)+` +
-		string(tagsSep) +
-		`(.*\s)+` // set s flag: . also matches newline
-	re := regexp.MustCompile(pattern)
+// Prompter opens the cards selected by review in the editor. Reviewed cards
+// are recorded in the journal j, toggles found in it are not opened again.
+func Prompter(ctx context.Context, cards <-chan card2, editedCards chan<- card2, review review, j *journal, errc chan<- error, wg *sync.WaitGroup, mutations ...options) {
+	defer wg.Done()
+	defer close(editedCards)
+	fail := func(err error) { report(ctx, errc, &PipelineError{Stage: stageReview, Err: err}) }
 
-	matches := re.FindAllSubmatchIndex(raw, -1)
-	cards := make([]card2, 0, len(matches))
-	for _, idxs := range matches {
-		// fmt.Printf("%q\n", raw[idxs[0]:idxs[1]]) // whole
-		// fmt.Printf("%q\n", raw[idxs[2]:idxs[3]]) // front (no newlines permitted, so right match), includes newline
-		// fmt.Printf("%q\n", raw[idxs[4]:idxs[5]]) // last match of back
-		// fmt.Printf("%q\n", raw[idxs[6]:idxs[7]]) // last match of tags inc. \n
-		card := card2{}
+	doMutations := yieldDoMutation(mutations...)
+	send := func(c card2) bool {
+		select {
+		case editedCards <- c:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
 
-		// front
-		start, end := idxs[2], idxs[3]
-		card.front = raw[start : end-len("\n")]
+	var (
+		fp     string   // the file the cards are edited in.
+		editor []string // only look for an editor if a card is reviewed.
+	)
+	defer func() {
+		if fp != "" {
+			os.Remove(fp)
+		}
+	}()
+	for card := range cards {
+		if cs, ok := j.reviewed(card); ok {
+			for _, c := range cs {
+				if !send(c) {
+					return
+				}
+			}
+			continue
+		}
 
-		// back
-		start, end = end+len(backSep), idxs[5]
-		card.back = raw[start:end]
+		// apply patches such as converting to mathjax format.
+		doMutations(&card)
 
-		// tags:
-		start, end = end+len(tagsSep), idxs[7]
-		card.tags = bytes.Split(raw[start:end], []byte{'\n'})
+		problems := lintCard(card)
+		for _, p := range problems {
+			log.Printf("Card %q: %s.\n", card.front, p)
+		}
+		if !review.edit(problems) {
+			if !send(card) {
+				return
+			}
+			continue
+		}
 
-		if VERBOSE {
-			log.Println(card)
+		if editor == nil {
+			f, err := os.CreateTemp("", "md2anki-*.txt")
+			if err != nil {
+				fail(err)
+				return
+			}
+			fp = f.Name()
+			f.Close()
+			cmd, err := getCmd(fp)
+			if err != nil {
+				fail(err)
+				return
+			}
+			editor = cmd
 		}
-		cards = append(cards, card)
-	}
 
-	if len(matches) == 0 {
-		log.Printf("Could not find any matching pattern in:\n%s\nBe sure not to mess with the separators.", string(raw))
-		return nil, nil
-	}
+		if err := createPrompt(fp, &card); err != nil {
+			fail(err)
+			return
+		}
 
-	return cards, nil
+		cs, err := editPrompt(editor, fp)
+		if err != nil && err != skipNote {
+			fail(err)
+			return
+		}
+		edited := make([]card2, len(cs))
+		for i, c := range cs {
+			// the first card keeps the identity of the toggle, cards split off
+			// of it get their own.
+			edited[i] = card
+			edited[i].front, edited[i].back, edited[i].tags = c.front, c.back, c.tags
+			edited[i].deck, edited[i].cloze = c.deck, c.cloze
+			if i > 0 {
+				edited[i].guid += strconv.Itoa(i)
+			}
+		}
+		if err := j.record(card.guid, edited); err != nil {
+			fail(err)
+			return
+		}
+		for _, c := range edited {
+			if !send(c) {
+				return
+			}
+		}
+	}
 }
 
-// Blocks until finished. Panics on failure.
-func runCommand(exe *exec.Cmd) {
-	exe.Stdin = os.Stdin
-	exe.Stdout = os.Stdout
-	errp, err := exe.StderrPipe()
-	if err != nil {
-		log.Panic(err)
-	}
+// textHeader tells Anki how to import the text file. Notes with a known guid
+// are updated instead of added again.
+const textHeader = "#separator:comma\n#html:true\n#tags column:3\n#guid column:4\n#notetype column:5\n#deck column:6\n"
 
-	if err := exe.Start(); err != nil {
-		log.Panic(err)
+func Serialiser(ctx context.Context, fp string, cards <-chan card2, errc chan<- error, wg *sync.WaitGroup) {
+	defer wg.Done()
+	n, err := writeText(fp, cards)
+	if err == nil && ctx.Err() != nil {
+		// the cards are incomplete.
+		err = ctx.Err()
 	}
-
-	raw, err := io.ReadAll(errp)
 	if err != nil {
-		log.Println(string(raw))
-		log.Panic(err)
-	}
-
-	if err := exe.Wait(); err != nil {
-		log.Panic(err)
+		os.Remove(fp)
+		if ctx.Err() == nil {
+			report(ctx, errc, &PipelineError{Stage: stageWrite, Err: err})
+		}
+		return
 	}
+	log.Printf("Done. Added %d cards to %q.\n", n, fp)
 }
 
-func Serialiser(fp string, cards <-chan card2, wg *sync.WaitGroup) {
-	var n int
-	out, err := os.OpenFile(fp, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0700)
+// writeText writes the cards as a text file for Anki's import to fp.
+func writeText(fp string, cards <-chan card2) (n int, err error) {
+	out, err := os.OpenFile(fp, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0600)
 	if err != nil {
-		panic(err)
+		return 0, err
+	}
+	defer closeFile(out, &err)
+	// see https://docs.ankiweb.net/importing/text-files.html#file-headers
+	if _, err := out.WriteString(textHeader); err != nil {
+		return 0, err
 	}
-	defer saveClose(out)
 	w := csv.NewWriter(out)
 
 	for card := range cards {
-		w.Write([]string{string(card.front), string(card.back), string(bytes.Join(card.tags, []byte{' '}))})
+		noteType, first, second := noteFields(card)
+		w.Write([]string{string(first), string(second), string(bytes.Join(card.tags, []byte{' '})), card.guid, noteType, card.deck})
 		n++
 		w.Flush()
+		if err := w.Error(); err != nil {
+			return n, err
+		}
 	}
-	if err := w.Error(); err != nil {
-		log.Println(err)
-		return
-	}
-	log.Printf("Done. Added %d cards to %q.\n", n, fp)
-	wg.Done()
+	return n, nil
 }
 
 type options int
@@ -570,6 +706,8 @@ const (
 	// Add options here.
 	toMathJax options = 1 << iota
 	includeMedia
+	toCloze
+	toHTML
 )
 
 func yieldDoMutation(mutations ...options) func(c *card2) {
@@ -580,6 +718,8 @@ func yieldDoMutation(mutations ...options) func(c *card2) {
 	return func(c *card2) {
 		for _, mu := range mutations {
 			switch mu {
+			case toCloze:
+				markCloze(c)
 			case toMathJax:
 				// turn $$...$$ into \(...\)
 				mlPattern := `\$\$(.+?)\$\$`
@@ -599,13 +739,23 @@ func yieldDoMutation(mutations ...options) func(c *card2) {
 				re := regexp.MustCompile(pattern)
 				c.front = re.ReplaceAll(c.front, []byte(`<img src="${1}_${2}">`)) // provides scoping
 				c.back = re.ReplaceAll(c.back, []byte(`<img src="${1}_${2}">`))  //provides scoping
+			case toHTML:
+				// cards of the HTML export are HTML already.
+				if c.html {
+					continue
+				}
+				c.front = renderMarkdownLine(c.front)
+				c.back = renderMarkdown(c.back)
+				c.html = true
 			}
 		}
 	}
 }
 
-func saveClose(f io.Closer) {
-	if err := f.Close(); err != nil {
-		panic(err)
+// closeFile closes f and reports a failure through err, unless there is an
+// error already. Writes to a file may only fail on close.
+func closeFile(f io.Closer, err *error) {
+	if cerr := f.Close(); cerr != nil && *err == nil {
+		*err = cerr
 	}
 }
\ No newline at end of file
@@ -5,12 +5,16 @@ import (
 	"log"
 	"os"
 	"regexp"
+	"strings"
 	"testing"
 )
 
 func TestPattern(t *testing.T) {
 	testPattern()
-	fp := "" // required you to export a page.
+	fp := os.Getenv("MD2ANKI_PAGE") // required you to export a page.
+	if fp == "" {
+		t.Skip("set MD2ANKI_PAGE to an exported notion page")
+	}
 	process(fp)
 }
 
@@ -43,35 +47,17 @@ func process(fp string) {
 	if err != nil {
 		log.Panic(err)
 	}
-	hr := regexp.MustCompile(headingExp)
-	hMatches := hr.FindAllSubmatch(raw, -1)
-	idxMatches := hr.FindAllSubmatchIndex(raw, -1)
-	fmt.Println("Heading matches:", len(hMatches))
-	fmt.Println(idxMatches)
-	for _, match := range hMatches {
-		// match is an array of the found matches as bytes.
-		for _, s := range match {
-			fmt.Printf("%q\n", string(s))
-		}
-		// build tag stack... need order of regexp... use index? and then describe the underlying raw data?
-	}
-
-	tr := regexp.MustCompile(toggleExp)
-	tMatches := tr.FindAllSubmatch(raw, -1)
-	idxMatches = tr.FindAllSubmatchIndex(raw, -1)
-	fmt.Println(idxMatches)
-	fmt.Println("toggle matches:")
-	for _, match := range tMatches {
-		// match is an array of the found matches as bytes.
-		for _, s := range match {
-			fmt.Printf("%q\n", string(s))
-		}
-		// build tag stack... need order of regexp... use index? and then describe the underlying raw data?
-	}
-
+	printBlocks(parseMarkdown(raw), 0)
 	fmt.Printf("%#v\n", string(raw))
 }
 
+func printBlocks(nodes []*node, depth int) {
+	for _, n := range nodes {
+		fmt.Printf("%s%s: %q\n", strings.Repeat("\t", depth), n.kind, string(n.text))
+		printBlocks(n.children, depth+1)
+	}
+}
+
 func testPattern() error {
 	tog := `
 - How to switch to a certain window (labelled with a number)
@@ -84,14 +70,10 @@ func testPattern() error {
 	<ident>
 
 `
-	tr := regexp.MustCompile(toggleExp)
 	fmt.Println("Match toggle:")
-	fmt.Printf("Pattern: %q\n", tr.String())
 	fmt.Printf("Search: %#v\n", tog)
 	fmt.Println("Matches:")
-	for _, slice := range tr.FindAllStringSubmatch(tog, -1) {
-		fmt.Printf("%#v\n", slice)
-	}
+	printBlocks(parseMarkdown([]byte(tog)), 0)
 
 	head := `
 # Terminal multiplexer
@@ -100,12 +82,96 @@ bar foo
 ### Commands foo bar 
 `
 
-	hr := regexp.MustCompile(headingExp)
 	fmt.Println("\nMatch heading:")
-	fmt.Printf("Pattern: %q\n", hr.String())
 	fmt.Printf("Search: %#v\n", head)
-	hMatches := hr.FindAllStringSubmatch(head, -1)
-	fmt.Printf("Matches: %#v\n", hMatches)
-	fmt.Printf("%v\n", newTag([]byte(hMatches[0][0]))) // not 0, 0 is full string
+	hMatches := parseMarkdown([]byte(head))
+	printBlocks(hMatches, 0)
+	fmt.Printf("%v\n", newTag(hMatches[0], false))
 	return nil
-}
\ No newline at end of file
+}
+
+func TestNoteGUID(t *testing.T) {
+	page := "# Page\n\n- a\n\n    1\n\n- b\n\n    2\n\n- a\n\n    3\n"
+	edited := "# Page\n\n- b\n\n    changed\n\n- new\n\n    4\n\n- a\n\n    1\n\n- a\n\n    3\n"
+
+	guids := map[string]bool{}
+	for _, c := range collectCards(page) {
+		if guids[c.guid] {
+			t.Errorf("duplicate guid %q", c.guid)
+		}
+		guids[c.guid] = true
+	}
+	for _, c := range collectCards(edited) {
+		if string(c.front) != "new" && !guids[c.guid] {
+			t.Errorf("card %q got a new guid after editing the page", c.front)
+		}
+	}
+
+	if noteGUID("id1", []byte("a"), 0) == noteGUID("id2", []byte("a"), 0) {
+		t.Error("pages with different ids share a guid")
+	}
+	if got := pageID("/tmp/Some Page 0123abcd.md"); got != "0123abcd" {
+		t.Errorf("pageID = %q", got)
+	}
+}
+
+func TestTagStrategy(t *testing.T) {
+	page := "# Page\n\n- top\n\n\t1\n\n## ABC\n\n### b\n\n- deep\n\n\t2\n\n\t- nested\n\n\t\t3\n"
+	defer func() { tagStrategy, nestedToggles = tagsHeadings, nestedKeep }()
+	nestedToggles = nestedCards
+	tests := []struct {
+		strategy tagPolicy
+		want     []string
+	}{
+		{tagsHeadings, []string{"", "ABC b", "ABC b deep"}},
+		{tagsHierarchical, []string{"Page", "Page::ABC::b", "Page::ABC::b::deep"}},
+		{tagsNone, []string{"", "", ""}},
+	}
+	for _, tt := range tests {
+		tagStrategy = tt.strategy
+		cs := collectCards(page)
+		if len(cs) != len(tt.want) {
+			t.Fatalf("%v: got %d cards, want %d", tt.strategy, len(cs), len(tt.want))
+		}
+		for i, c := range cs {
+			if got := string(joinTags(c.tags)); got != tt.want[i] {
+				t.Errorf("%v: card %q has tags %q, want %q", tt.strategy, c.front, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestSubdecks(t *testing.T) {
+	page := "# Page\n\n- top\n\n\t1\n\n# Part one\n\n## b\n\n- deep\n\n\t2\n\n# Part two\n\n- other\n\n\t3\n"
+	defer func() { subdeckDepth, tagStrategy = 0, tagsHeadings }()
+	subdeckDepth = 1
+	want := []struct{ deck, tags string }{
+		{"Page", ""},
+		{"Page::Part one", "b"},
+		{"Page::Part two", ""},
+	}
+	cs := collectCards(page)
+	if len(cs) != len(want) {
+		t.Fatalf("got %d cards, want %d", len(cs), len(want))
+	}
+	for i, c := range cs {
+		if c.deck != want[i].deck || string(joinTags(c.tags)) != want[i].tags {
+			t.Errorf("card %q is in %q with tags %q, want %+v", c.front, c.deck, joinTags(c.tags), want[i])
+		}
+	}
+
+	tagStrategy = tagsHierarchical
+	if cs := collectCards(page); string(joinTags(cs[1].tags)) != "Page::Part_one::b" {
+		t.Errorf("hierarchical tag = %q, want it below the subdeck", joinTags(cs[1].tags))
+	}
+	defer func() { tagCase = tagCaseKeep }()
+	tagCase = tagCaseKebab
+	if cs := collectCards(page); string(joinTags(cs[1].tags)) != "page::part-one::b" {
+		t.Errorf("kebab case hierarchical tag = %q", joinTags(cs[1].tags))
+	}
+
+	// "::" in a heading does not start another subdeck.
+	if cs := collectCards("# Page\n\n# C::D\n\n- q\n\n\ta\n"); len(cs) != 1 || cs[0].deck != "Page::C:D" {
+		t.Errorf("cards = %v, want one in Page::C:D", cs)
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// With -tui the cards are reviewed in the terminal instead of an editor:
+/*
+ md2anki  card 37/212  accepted
+ deck: Page   type: Basic   tags: ABC b
+ ──────────
+ What is entropy?
+ ──────────
+ The average information.
+ ──────────
+ [enter] accept  [s] skip  [x] split  [f]ront [b]ack [t]ags [d]eck  [←/→] move  [q] finish
+*/
+// All cards are collected first, so the reviewer knows how many there are and
+// can go back to cards decided before. The accepted cards are written once the
+// review is finished. Every decision is recorded in the journal right away.
+
+// reviewTUI reviews the cards in the terminal instead of the editor.
+var reviewTUI bool
+
+// errInterrupted is returned if the review is stopped with Ctrl+C. The raw
+// terminal turns it into a key instead of a signal.
+var errInterrupted = fmt.Errorf("review interrupted: %w", context.Canceled)
+
+type tui struct {
+	*reviewSession
+	cur int // index into view.
+
+	in         *bufio.Reader
+	out        io.Writer
+	rows, cols int
+	status     string
+}
+
+// TUIReviewer reviews the cards selected by review in the terminal. It sends
+// the same cards Prompter does.
+func TUIReviewer(ctx context.Context, cards <-chan card2, editedCards chan<- card2, review review, j *journal, errc chan<- error, wg *sync.WaitGroup, mutations ...options) {
+	defer wg.Done()
+	defer close(editedCards)
+	fail := func(err error) { report(ctx, errc, &PipelineError{Stage: stageReview, Err: err}) }
+
+	cs := collectReview(cards, j, mutations...)
+	if ctx.Err() != nil {
+		return
+	}
+
+	term, err := openTerminal()
+	if err != nil {
+		fail(err)
+		return
+	}
+	rows, cols := term.size()
+	t := newTUI(cs, review, j, term, term, rows, cols)
+	if len(t.view) > 0 {
+		err = t.run()
+	}
+	term.restore()
+	if err != nil {
+		fail(err)
+		return
+	}
+	t.sendAccepted(ctx, editedCards)
+}
+
+// newTUI prepares the review of cards in the terminal.
+func newTUI(cards []card2, review review, j *journal, in io.Reader, out io.Writer, rows, cols int) *tui {
+	return &tui{reviewSession: newReviewSession(cards, review, j), in: bufio.NewReader(in), out: out, rows: rows, cols: cols}
+}
+
+// run handles keys until the review is finished.
+func (t *tui) run() error {
+	fmt.Fprint(t.out, "\x1b[?1049h") // alternate screen
+	defer fmt.Fprint(t.out, "\x1b[?1049l")
+	quit := false
+	for {
+		t.draw()
+		key, err := t.readKey()
+		if err != nil {
+			return err
+		}
+		if key != "q" {
+			quit = false
+		}
+		t.status = ""
+		it := t.view[t.cur]
+		switch key {
+		case "\r", "\n", "a":
+			t.decide(it, stateAccepted)
+			t.move(1)
+		case "s":
+			t.decide(it, stateSkipped)
+			t.move(1)
+		case "x":
+			t.split(it, t.cur)
+			t.cur++
+			t.status = "Split off a new card, edit it with f, b, t and d."
+		case "f", "b", "t", "d":
+			t.edit(it, key)
+		case "left", "p", "k":
+			t.move(-1)
+		case "right", "n", "j":
+			t.move(1)
+		case "q":
+			pending := t.pending()
+			if pending == 0 || quit {
+				t.acceptPending()
+				return t.err
+			}
+			quit = true
+			t.status = fmt.Sprintf("%d cards are not reviewed yet, press q again to accept them.", pending)
+		case "ctrl-c":
+			return errInterrupted
+		}
+		if t.err != nil {
+			return t.err
+		}
+	}
+}
+
+func (t *tui) move(d int) {
+	if i := t.cur + d; i >= 0 && i < len(t.view) {
+		t.cur = i
+	}
+}
+
+// edit changes a field of the card in a line editor.
+func (t *tui) edit(it *reviewItem, field string) {
+	c := &it.card
+	var name, value string
+	switch field {
+	case "f":
+		name, value = "Front", string(c.front)
+	case "b":
+		name, value = "Back", strings.TrimRight(string(c.back), "\n")
+	case "t":
+		name, value = "Tags", string(joinTags(c.tags))
+	case "d":
+		name, value = "Deck", c.deck
+	}
+	value, ok := t.readLine(name+": ", value)
+	if !ok {
+		t.status = "Not changed."
+		return
+	}
+	t.setField(it, field, value)
+}
+
+// readKey reads a key press: a character, "left", "right", "up", "down",
+// "esc" or "ctrl-c".
+func (t *tui) readKey() (string, error) {
+	r, _, err := t.in.ReadRune()
+	if err != nil {
+		if err == io.EOF {
+			return "", errInterrupted
+		}
+		return "", err
+	}
+	switch r {
+	case 3:
+		return "ctrl-c", nil
+	case 0x1b:
+		if t.in.Buffered() == 0 {
+			return "esc", nil
+		}
+		if b, _ := t.in.Peek(2); len(b) == 2 && b[0] == '[' {
+			t.in.Discard(2)
+			switch b[1] {
+			case 'A':
+				return "up", nil
+			case 'B':
+				return "down", nil
+			case 'C':
+				return "right", nil
+			case 'D':
+				return "left", nil
+			}
+			return "", nil
+		}
+		return "esc", nil
+	}
+	return string(r), nil
+}
+
+// readLine edits value on the last line of the screen. Enter confirms, Esc
+// cancels and Ctrl+J starts a new line, which is shown as ↵.
+func (t *tui) readLine(prompt, value string) (string, bool) {
+	buf := []rune(value)
+	pos := len(buf)
+	for {
+		shown := strings.ReplaceAll(string(buf), "\n", "↵")
+		fmt.Fprintf(t.out, "\x1b[%d;1H\x1b[2K%s%s", t.rows, prompt, shown)
+		if back := len(buf) - pos; back > 0 {
+			fmt.Fprintf(t.out, "\x1b[%dD", back)
+		}
+		key, err := t.readKey()
+		if err != nil {
+			return "", false
+		}
+		switch key {
+		case "\r":
+			return string(buf), true
+		case "esc", "ctrl-c":
+			return "", false
+		case "\n":
+			buf = append(buf[:pos], append([]rune{'\n'}, buf[pos:]...)...)
+			pos++
+		case "\x7f", "\b":
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case "\x15": // Ctrl+U
+			buf, pos = buf[pos:], 0
+		case "left":
+			if pos > 0 {
+				pos--
+			}
+		case "right":
+			if pos < len(buf) {
+				pos++
+			}
+		default:
+			if r := []rune(key); len(r) == 1 && unicode.IsPrint(r[0]) {
+				buf = append(buf[:pos], append([]rune{r[0]}, buf[pos:]...)...)
+				pos++
+			}
+		}
+	}
+}
+
+// draw shows the current card. Lines end with \r\n, because the raw
+// terminal does not return the carriage.
+func (t *tui) draw() {
+	it := t.view[t.cur]
+	var lines []string
+	noteType, _, _ := noteFields(it.card)
+	lines = append(lines,
+		fmt.Sprintf("\x1b[7m md2anki  card %d/%d  %s \x1b[0m", t.cur+1, len(t.view), it.state),
+		fmt.Sprintf("deck: %s   type: %s   tags: %s", it.card.deck, noteType, joinTags(it.card.tags)))
+	rule := strings.Repeat("─", min(t.cols, 80))
+	lines = append(lines, rule)
+	lines = append(lines, strings.Split(string(it.card.front), "\n")...)
+	lines = append(lines, rule)
+	lines = append(lines, strings.Split(strings.TrimRight(string(it.card.back), "\n"), "\n")...)
+	lines = append(lines, rule)
+
+	var footer []string
+	for _, p := range it.problems {
+		footer = append(footer, "\x1b[33m! "+p+"\x1b[0m")
+	}
+	if t.status != "" {
+		footer = append(footer, t.status)
+	}
+	footer = append(footer, "[enter] accept  [s] skip  [x] split  [f]ront [b]ack [t]ags [d]eck  [←/→] move  [q] finish")
+
+	// cut the back, so the footer stays on the screen.
+	if room := t.rows - len(footer) - 1; len(lines) > room && room > 0 {
+		lines = append(lines[:room-1], "…")
+	}
+	fmt.Fprint(t.out, "\x1b[H\x1b[2J")
+	fmt.Fprint(t.out, strings.Join(append(lines, footer...), "\r\n"))
+}
+
+// terminal is the controlling terminal in raw mode.
+type terminal struct {
+	*os.File
+	saved string // the settings to restore.
+}
+
+func openTerminal() (*terminal, error) {
+	if runtime.GOOS == "windows" {
+		return nil, errors.New("the terminal review is not supported on Windows, use the editor")
+	}
+	f, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("the terminal review needs a terminal: %v", err)
+	}
+	saved, err := stty(f, "-g")
+	if err == nil {
+		_, err = stty(f, "raw", "-echo")
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &terminal{File: f, saved: saved}, nil
+}
+
+func (t *terminal) size() (rows, cols int) {
+	out, err := stty(t.File, "size")
+	if _, serr := fmt.Sscanf(out, "%d %d", &rows, &cols); err != nil || serr != nil {
+		return 24, 80
+	}
+	return rows, cols
+}
+
+func (t *terminal) restore() {
+	stty(t.File, t.saved)
+	t.Close()
+}
+
+// stty runs stty on the terminal tty.
+func stty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("stty %s: %v", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
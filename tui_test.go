@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTUIReview(t *testing.T) {
+	cards := []card2{
+		{front: []byte("A"), back: []byte("a"), guid: "a"},
+		{front: []byte("B"), back: []byte("b"), guid: "b"},
+		{front: []byte("C"), back: []byte("c"), guid: "c", tags: [][]byte{[]byte("old")}},
+	}
+	fp := filepath.Join(t.TempDir(), "session.jsonl")
+	j, err := openJournal(fp, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := strings.Join([]string{
+		"\r",                   // accept A
+		"s",                    // skip B
+		"x", "f\x15C2\r", "\r", // split C, edit the front of the copy and accept it
+		"\x1b[D", "t\x15new tags\r", // back to C and retag it
+		"q", "q", // accept C, which is not reviewed yet
+	}, "")
+	tu := newTUI(cards, reviewAll, j, strings.NewReader(keys), io.Discard, 24, 80)
+	if err := tu.run(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		front, guid, tags string
+		state             cardState
+	}{
+		{"A", "a", "", stateAccepted},
+		{"B", "b", "", stateSkipped},
+		{"C", "c", "new tags", stateAccepted},
+		{"C2", "c1", "old", stateAccepted},
+	}
+	if len(tu.all) != len(want) {
+		t.Fatalf("got %d cards, want %d", len(tu.all), len(want))
+	}
+	for i, w := range want {
+		it := tu.all[i]
+		if string(it.card.front) != w.front || it.card.guid != w.guid || string(joinTags(it.card.tags)) != w.tags || it.state != w.state {
+			t.Errorf("card %d = %q %q %q %v, want %+v", i, it.card.front, it.card.guid, joinTags(it.card.tags), it.state, w)
+		}
+	}
+
+	// a resumed review starts with the same decisions.
+	j.close(false)
+	if j, err = openJournal(fp, true); err != nil {
+		t.Fatal(err)
+	}
+	defer j.close(true)
+	resumed := newTUI(cards, reviewAll, j, strings.NewReader("q"), io.Discard, 24, 80)
+	if len(resumed.all) != len(want) || resumed.all[1].state != stateSkipped || string(resumed.all[3].card.front) != "C2" {
+		t.Errorf("resumed review differs")
+	}
+}
+
+func TestTUIInterrupt(t *testing.T) {
+	cards := []card2{{front: []byte("A"), guid: "a"}}
+	tu := newTUI(cards, reviewAll, nil, strings.NewReader("\x03"), io.Discard, 24, 80)
+	if err := tu.run(); err != errInterrupted {
+		t.Errorf("err = %v, want errInterrupted", err)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// md2anki watch <dir> processes every Notion export saved to dir, e.g. the
+// Downloads folder, without opening the editor. Pages and zip files directly
+// in dir are checked every watchInterval. A file is processed once it has not
+// changed for one interval, so an export which is still being written is left
+// alone. Files already in dir when watching starts are not processed until
+// they change.
+// Like every run, only the toggles which are new or changed since the last
+// run on the same export are written, see exportState.
+
+// watchMode watches a folder instead of processing a single export.
+var watchMode bool
+
+// watchInterval is the time between two looks at the watched folder.
+var watchInterval = 2 * time.Second
+
+type fileStamp struct {
+	size int64
+	mod  time.Time
+}
+
+type watchedFile struct {
+	last      fileStamp // seen on the last look.
+	processed fileStamp
+}
+
+type watcher struct {
+	dp        string
+	out       output
+	media     bool // move the media files like a run with -media.
+	mutations []options
+
+	files map[string]*watchedFile
+	// extracted are the folders of zip files extracted by the watcher, which
+	// may be replaced when the zip file changes.
+	extracted map[string]bool
+}
+
+// watch processes the exports saved to the folder dp until ctx is cancelled.
+func watch(ctx context.Context, dp string, out output, media bool, mutations ...options) error {
+	w := &watcher{
+		dp: dp, out: out, media: media, mutations: mutations,
+		files:     map[string]*watchedFile{},
+		extracted: map[string]bool{},
+	}
+	// the exports already there are not new.
+	if err := w.look(ctx, true); err != nil {
+		return err
+	}
+	log.Printf("Watching %q for Notion exports, stop with Ctrl+C.\n", dp)
+	tick := time.NewTicker(watchInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick.C:
+			if err := w.look(ctx, false); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// look checks the folder for exports and processes the ones which stopped
+// changing. With initial, nothing is processed.
+func (w *watcher) look(ctx context.Context, initial bool) error {
+	entries, err := os.ReadDir(w.dp)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !isExport(name) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			// removed in the meantime.
+			continue
+		}
+		stamp := fileStamp{info.Size(), info.ModTime()}
+		f, ok := w.files[name]
+		if !ok {
+			f = &watchedFile{}
+			w.files[name] = f
+			if initial {
+				f.processed = stamp
+			}
+		}
+		if stamp != f.last {
+			// still being written, wait for the next look.
+			f.last = stamp
+			if !initial {
+				continue
+			}
+		}
+		if stamp == f.processed || ctx.Err() != nil {
+			continue
+		}
+		f.processed = stamp
+		if err := w.process(ctx, filepath.Join(w.dp, name)); err != nil {
+			log.Printf("%s: %v\n", name, err)
+		}
+	}
+	return nil
+}
+
+// isExport reports whether the file name is a page or a zip file of Notion's
+// export.
+func isExport(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".md" || ext == ".zip" || isHTMLPage(name)
+}
+
+// process writes the new and changed notes of the export fp.
+func (w *watcher) process(ctx context.Context, fp string) error {
+	pages, err := w.pages(fp)
+	if err != nil {
+		return &PipelineError{Stage: stageRead, Err: err}
+	}
+
+	log.Printf("Processing %q.\n", fp)
+	if err := ProcessPages(ctx, pages, fp, w.out, reviewNone, w.mutations...); err != nil {
+		return err
+	}
+	if w.media && w.out == outputText {
+		for _, p := range pages {
+			addMedia(p.fp)
+		}
+	}
+	return nil
+}
+
+// pages returns the pages of the export fp. A zip file extracted by the
+// watcher before is extracted again, as it may hold other pages now.
+func (w *watcher) pages(fp string) ([]page, error) {
+	if !isWorkspace(fp) {
+		return []page{{fp: fp, deck: pageTitleToDeckName(fp)}}, nil
+	}
+	root := strings.TrimSuffix(fp, filepath.Ext(fp))
+	if w.extracted[root] {
+		if err := os.RemoveAll(root); err != nil {
+			return nil, err
+		}
+	} else if exists(root) {
+		log.Printf("%q is extracted already, its pages are used as they are.\n", root)
+	}
+	extract := !exists(root)
+	pages, err := workspacePages(fp)
+	if err == nil && extract {
+		w.extracted[root] = true
+	}
+	return pages, err
+}
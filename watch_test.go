@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	chdir(t, t.TempDir())
+	dp := t.TempDir()
+	w := &watcher{dp: dp, out: outputText, files: map[string]*watchedFile{}, extracted: map[string]bool{}}
+	ctx := context.Background()
+	look := func() {
+		t.Helper()
+		if err := w.look(ctx, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	page := filepath.Join(dp, "Page abc.md")
+	write := func(raw string, mod time.Time) {
+		t.Helper()
+		if err := os.WriteFile(page, []byte(raw), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(page, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	output := func() string {
+		t.Helper()
+		raw, err := os.ReadFile("Page.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Remove("Page.txt")
+		return strings.TrimPrefix(string(raw), textHeader)
+	}
+
+	if err := w.look(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	write("- A\n\n\ta\n- B\n\n\tb\n", now)
+	look()
+	if exists("Page.txt") {
+		t.Fatal("processed a page which may still be written")
+	}
+	look()
+	if out := output(); !strings.Contains(out, "A,\"a") || !strings.Contains(out, "B,\"b") {
+		t.Errorf("first run wrote %q, want A and B", out)
+	}
+
+	look()
+	if exists("Page.txt") {
+		t.Error("processed an unchanged page again")
+	}
+
+	write("- A\n\n\ta\n- B\n\n\tchanged\n", now.Add(time.Second))
+	look()
+	look()
+	if out := output(); strings.Contains(out, "A,\"a") || !strings.Contains(out, "B,\"changed") {
+		t.Errorf("second run wrote %q, want only B", out)
+	}
+}
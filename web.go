@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// md2anki serve <page> reviews the cards in the browser instead of an editor.
+// Every card is shown as Anki shows it, with MathJax and the images of the
+// media folder, and can be accepted, skipped, split and edited there:
+/*
+	GET  /             all cards
+	POST /card         action=accept|skip|split|save of the card with index i
+	POST /finish       writes the accepted cards and stops the server
+	GET  /media/{name} the media file referenced as name
+*/
+// Like with -tui, the accepted cards are written once the review is finished
+// and every decision is recorded in the journal right away. MathJax is loaded
+// from a CDN, offline the math stays TeX.
+
+// serveMode reviews the cards in the browser.
+var serveMode bool
+
+// serveAddr is the address the review server listens on. AnkiConnect takes
+// 8765 already.
+var serveAddr = "127.0.0.1:8787"
+
+type webReview struct {
+	mu sync.Mutex // guards the session.
+	*reviewSession
+
+	mediaDps []string
+	// token is sent with every form, so other sites cannot post to the
+	// server.
+	token string
+	done  chan struct{}
+	once  sync.Once
+}
+
+// WebReviewer reviews the cards selected by review in the browser. It sends
+// the same cards Prompter does.
+func WebReviewer(ctx context.Context, cards <-chan card2, editedCards chan<- card2, review review, j *journal, mediaDps []string, errc chan<- error, wg *sync.WaitGroup, mutations ...options) {
+	defer wg.Done()
+	defer close(editedCards)
+	fail := func(err error) { report(ctx, errc, &PipelineError{Stage: stageReview, Err: err}) }
+
+	cs := collectReview(cards, j, mutations...)
+	if ctx.Err() != nil {
+		return
+	}
+
+	w, err := newWebReview(newReviewSession(cs, review, j), mediaDps)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if len(w.view) > 0 {
+		ln, err := net.Listen("tcp", serveAddr)
+		if err != nil {
+			fail(err)
+			return
+		}
+		log.Printf("Review the cards at http://%s/ and finish there to write them.\n", ln.Addr())
+		if err := w.serve(ctx, ln); err != nil {
+			fail(err)
+			return
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	w.sendAccepted(ctx, editedCards)
+}
+
+func newWebReview(s *reviewSession, mediaDps []string) (*webReview, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	return &webReview{reviewSession: s, mediaDps: mediaDps, token: hex.EncodeToString(token), done: make(chan struct{})}, nil
+}
+
+// serve handles requests on ln until the review is finished or ctx is
+// cancelled.
+func (w *webReview) serve(ctx context.Context, ln net.Listener) error {
+	srv := &http.Server{Handler: w.handler()}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+	case err := <-served:
+		return err
+	}
+	// lets the answer to /finish through.
+	srv.Shutdown(context.Background())
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// handler routes by hand, the method and wildcard patterns of ServeMux need
+// a go.mod with Go 1.22.
+func (w *webReview) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", only(http.MethodGet, func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(rw, r)
+			return
+		}
+		w.index(rw, r)
+	}))
+	mux.HandleFunc("/card", only(http.MethodPost, w.card))
+	mux.HandleFunc("/finish", only(http.MethodPost, w.finish))
+	mux.HandleFunc("/media/", only(http.MethodGet, w.media))
+	return mux
+}
+
+// only serves the requests with method by h.
+func only(method string, h http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			rw.Header().Set("Allow", method)
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h(rw, r)
+	}
+}
+
+type webCard struct {
+	Index, N    int
+	State       string
+	Class       string // the state as a CSS class.
+	Deck        string
+	Type        string
+	Tags        string
+	Front, Back template.HTML // as rendered by Anki.
+	FrontText   string
+	BackText    string
+	Problems    []string
+}
+
+func (w *webReview) index(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	data := struct {
+		Token   string
+		Pending int
+		Cards   []webCard
+	}{Token: w.token, Pending: w.pending()}
+	for i, it := range w.view {
+		c := it.card
+		noteType, first, second := noteFields(c)
+		data.Cards = append(data.Cards, webCard{
+			Index:     i,
+			N:         i + 1,
+			State:     it.state.String(),
+			Class:     [...]string{"pending", "accepted", "skipped"}[it.state],
+			Deck:      c.deck,
+			Type:      noteType,
+			Tags:      string(joinTags(c.tags)),
+			Front:     template.HTML(fieldHTML(first, c.html)),
+			Back:      template.HTML(fieldHTML(second, c.html)),
+			FrontText: string(c.front),
+			BackText:  string(c.back),
+			Problems:  it.problems,
+		})
+	}
+	w.mu.Unlock()
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := reviewPage.Execute(rw, data); err != nil {
+		log.Println(err)
+	}
+}
+
+// card applies the action of a form to a card and goes back to it.
+func (w *webReview) card(rw http.ResponseWriter, r *http.Request) {
+	if r.FormValue("token") != w.token {
+		http.Error(rw, "invalid token, reload the page", http.StatusForbidden)
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	i, err := strconv.Atoi(r.FormValue("i"))
+	if err != nil || i < 0 || i >= len(w.view) {
+		http.Error(rw, "unknown card", http.StatusBadRequest)
+		return
+	}
+	it := w.view[i]
+	switch r.FormValue("action") {
+	case "accept":
+		w.decide(it, stateAccepted)
+	case "skip":
+		w.decide(it, stateSkipped)
+	case "split":
+		w.split(it, i)
+		i++
+	case "save":
+		for _, field := range []string{"f", "b", "t", "d"} {
+			w.setField(it, field, r.FormValue(field))
+		}
+	default:
+		http.Error(rw, "unknown action", http.StatusBadRequest)
+		return
+	}
+	if w.err != nil {
+		http.Error(rw, w.err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(rw, r, fmt.Sprintf("/#card-%d", i), http.StatusSeeOther)
+}
+
+// finish accepts the cards not reviewed yet and ends the review.
+func (w *webReview) finish(rw http.ResponseWriter, r *http.Request) {
+	if r.FormValue("token") != w.token {
+		http.Error(rw, "invalid token, reload the page", http.StatusForbidden)
+		return
+	}
+	w.mu.Lock()
+	w.acceptPending()
+	w.mu.Unlock()
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(rw, "<!DOCTYPE html><title>md2anki</title><p>The review is finished, you can close this page.")
+	w.once.Do(func() { close(w.done) })
+}
+
+// media serves the file of the media folders the cards reference as name,
+// see mediaName.
+func (w *webReview) media(rw http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/media/")
+	for _, dp := range w.mediaDps {
+		base := mediaName(dp, "")
+		if len(name) <= len(base) || name[:len(base)] != base {
+			continue
+		}
+		fp := filepath.Join(dp, filepath.Base(name[len(base):]))
+		if exists(fp) {
+			http.ServeFile(rw, r, fp)
+			return
+		}
+	}
+	http.NotFound(rw, r)
+}
+
+var reviewPage = template.Must(template.New("review").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>md2anki</title>
+<base href="/media/">
+<script>MathJax = {tex: {inlineMath: [["\\(", "\\)"]], displayMath: [["\\[", "\\]"]]}};</script>
+<script async src="https://cdn.jsdelivr.net/npm/mathjax@3/es5/tex-chtml.js"></script>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: auto; background: #eee; }
+.card { background: white; margin: 1em 0; padding: 1em; border-radius: 6px; }
+.anki { font-family: arial; font-size: 20px; text-align: center; }
+.anki hr { margin: 1em 0; }
+.meta { color: #666; font-size: small; }
+.accepted { border-left: 6px solid #4caf50; }
+.skipped { border-left: 6px solid #9e9e9e; opacity: .6; }
+.problem { color: #b26a00; }
+textarea { width: 100%; font-family: monospace; }
+</style>
+</head>
+<body>
+<h1>md2anki</h1>
+<form method="post" action="/finish">
+<input type="hidden" name="token" value="{{.Token}}">
+<p>{{.Pending}} cards are not reviewed yet, finishing accepts them. <button>Finish and write the cards</button></p>
+</form>
+{{range .Cards}}
+<div class="card {{.Class}}" id="card-{{.Index}}">
+<p class="meta">card {{.N}}/{{len $.Cards}} · {{.State}} · deck: {{.Deck}} · type: {{.Type}} · tags: {{.Tags}}</p>
+{{range .Problems}}<p class="problem">! {{.}}</p>{{end}}
+<div class="anki">{{.Front}}<hr id="answer">{{.Back}}</div>
+<form method="post" action="/card">
+<input type="hidden" name="token" value="{{$.Token}}">
+<input type="hidden" name="i" value="{{.Index}}">
+<button name="action" value="accept">Accept</button>
+<button name="action" value="skip">Skip</button>
+<button name="action" value="split">Split</button>
+<details>
+<summary>Edit</summary>
+<p>Front<br><textarea name="f" rows="2">{{.FrontText}}</textarea></p>
+<p>Back<br><textarea name="b" rows="6">{{.BackText}}</textarea></p>
+<p>Tags <input name="t" value="{{.Tags}}"> Deck <input name="d" value="{{.Deck}}"></p>
+<button name="action" value="save">Save</button>
+</details>
+</form>
+</div>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWebReview(t *testing.T) {
+	dp := filepath.Join(t.TempDir(), "Page")
+	if err := os.Mkdir(dp, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dp, "a.png"), []byte("png"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cards := []card2{
+		{front: []byte("A"), back: []byte(`<img src="Page_a.png">`), guid: "a", html: true},
+		{front: []byte("B"), back: []byte("b"), guid: "b"},
+	}
+	w, err := newWebReview(newReviewSession(cards, reviewAll, nil), []string{dp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(w.handler())
+	defer srv.Close()
+	client := srv.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	post := func(path string, form url.Values) int {
+		t.Helper()
+		resp, err := client.PostForm(srv.URL+path, form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	get := func(path string) (int, string) {
+		t.Helper()
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if code, body := get("/"); code != http.StatusOK || !strings.Contains(body, `<img src="Page_a.png">`) || !strings.Contains(body, w.token) {
+		t.Errorf("GET / = %d %q", code, body)
+	}
+	if code, body := get("/media/Page_a.png"); code != http.StatusOK || body != "png" {
+		t.Errorf("GET /media/Page_a.png = %d %q", code, body)
+	}
+	if code, _ := get("/media/Other_a.png"); code != http.StatusNotFound {
+		t.Errorf("GET /media/Other_a.png = %d, want 404", code)
+	}
+	if code, _ := get("/card"); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /card = %d, want 405", code)
+	}
+	if code, _ := get("/other"); code != http.StatusNotFound {
+		t.Errorf("GET /other = %d, want 404", code)
+	}
+
+	if code := post("/card", url.Values{"i": {"0"}, "action": {"skip"}}); code != http.StatusForbidden {
+		t.Errorf("post without token = %d, want 403", code)
+	}
+	steps := []url.Values{
+		{"i": {"0"}, "action": {"accept"}},
+		{"i": {"1"}, "action": {"split"}},
+		{"i": {"2"}, "action": {"save"}, "f": {"B2"}, "b": {"b2"}, "t": {"x y"}, "d": {"Deck"}},
+		{"i": {"1"}, "action": {"skip"}},
+	}
+	for _, form := range steps {
+		form.Set("token", w.token)
+		if code := post("/card", form); code != http.StatusSeeOther {
+			t.Fatalf("post %v = %d", form, code)
+		}
+	}
+	if code := post("/finish", url.Values{"token": {w.token}}); code != http.StatusOK {
+		t.Fatalf("finish = %d", code)
+	}
+	select {
+	case <-w.done:
+	default:
+		t.Error("the review is not done")
+	}
+
+	want := []struct {
+		front, guid, tags, deck string
+		state                   cardState
+	}{
+		{"A", "a", "", "", stateAccepted},
+		{"B", "b", "", "", stateSkipped},
+		{"B2", "b1", "x y", "Deck", stateAccepted},
+	}
+	if len(w.all) != len(want) {
+		t.Fatalf("got %d cards, want %d", len(w.all), len(want))
+	}
+	for i, wc := range want {
+		it := w.all[i]
+		if string(it.card.front) != wc.front || it.card.guid != wc.guid || string(joinTags(it.card.tags)) != wc.tags || it.card.deck != wc.deck || it.state != wc.state {
+			t.Errorf("card %d = %q %q %q %q %v, want %+v", i, it.card.front, it.card.guid, joinTags(it.card.tags), it.card.deck, it.state, wc)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Notion's "Export all" produces a zip with a folder tree like
+/*
+Export-3a7c.../
+	Parent abc.md
+	Parent abc/
+		image.png
+		Child def.md
+		Child def/
+			...
+*/
+// The HTML export has the same layout with .html pages.
+// Every page has a sibling folder of the same name holding its media files and
+// sub pages. The nesting becomes the deck hierarchy: Parent, Parent::Child.
+
+// isWorkspace reports whether fp is a workspace export, that is a zip file or
+// a directory, instead of a single page.
+func isWorkspace(fp string) bool {
+	if strings.EqualFold(filepath.Ext(fp), ".zip") {
+		return true
+	}
+	return isDir(fp)
+}
+
+// workspacePages returns all pages of the workspace export fp sorted by path.
+// A zip file is extracted next to it first, into a folder of the same name.
+func workspacePages(fp string) ([]page, error) {
+	root := fp
+	if !isDir(fp) {
+		root = strings.TrimSuffix(fp, filepath.Ext(fp))
+		if !exists(root) {
+			if err := extractZip(fp, root); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// the zip may wrap everything in a single folder.
+	for {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) != 1 || !entries[0].IsDir() {
+			break
+		}
+		root = filepath.Join(root, entries[0].Name())
+	}
+
+	var pages []page
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !(strings.EqualFold(filepath.Ext(path), ".md") || isHTMLPage(path)) {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		pages = append(pages, page{fp: path, deck: workspaceDeckName(rel)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("there are no pages in %q", fp)
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].fp < pages[j].fp })
+	return pages, nil
+}
+
+// outputName returns the name of the outputs for fp without extension. A
+// workspace export keeps the name of its zip or folder, which carries no page
+// id: "Notion Export" becomes "Notion_Export", not "Notion".
+func outputName(fp string) string {
+	if !isWorkspace(fp) {
+		return pageTitleToDeckName(fp)
+	}
+	name := filepath.Base(fp)
+	if !isDir(fp) {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return strings.ReplaceAll(name, " ", "_")
+}
+
+// workspaceDeckName turns the path of a page relative to the export root into
+// a deck name: "Parent abc/Child def.md" becomes "Parent::Child".
+func workspaceDeckName(rel string) string {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i := range parts {
+		parts[i] = pageTitleToDeckName(parts[i])
+	}
+	return strings.Join(parts, "::")
+}
+
+func extractZip(fp, dst string) error {
+	zr, err := zip.OpenReader(fp)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		path := filepath.Join(dst, filepath.FromSlash(f.Name))
+		// do not write outside of dst, see "zip slip".
+		if path != dst && !strings.HasPrefix(path, filepath.Clean(dst)+string(os.PathSeparator)) {
+			return fmt.Errorf("%q contains the invalid path %q", fp, f.Name)
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(path, 0700); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		if err := extractFile(f, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractFile(f *zip.File, path string) (err error) {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer closeFile(out, &err)
+	_, err = io.Copy(out, r)
+	return err
+}
+
+func isDir(fp string) bool {
+	info, err := os.Stat(fp)
+	return err == nil && info.IsDir()
+}
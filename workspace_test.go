@@ -0,0 +1,68 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWorkspacePages(t *testing.T) {
+	dir := t.TempDir()
+	fp := filepath.Join(dir, "Export-3a7c.zip")
+	f, err := os.Create(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, name := range []string{
+		"Export-3a7c/Parent abc.md",
+		"Export-3a7c/Parent abc/image.png",
+		"Export-3a7c/Parent abc/Child def.md",
+		"Export-3a7c/Parent abc/Child def/Grand Child 123.md",
+		"Export-3a7c/Other 456.md",
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("# Title\n"))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	pages, err := workspacePages(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decks []string
+	for _, p := range pages {
+		decks = append(decks, p.deck)
+		if !exists(p.fp) {
+			t.Errorf("page %q was not extracted", p.fp)
+		}
+	}
+	want := []string{"Other", "Parent", "Parent::Child", "Parent::Child::Grand_Child"}
+	if !reflect.DeepEqual(decks, want) {
+		t.Errorf("decks = %q, want %q", decks, want)
+	}
+	if name := MdToAnkiFilename(fp); name != "Export-3a7c.txt" {
+		t.Errorf("output of the zip = %q, want Export-3a7c.txt", name)
+	}
+}
+
+func TestWorkspaceOutputName(t *testing.T) {
+	dp := filepath.Join(t.TempDir(), "Notion Export")
+	if err := os.Mkdir(dp, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if name := MdToApkgFilename(dp); name != "Notion_Export.apkg" {
+		t.Errorf("output of the folder = %q, want Notion_Export.apkg", name)
+	}
+	if name := MdToAnkiFilename("Page abc.md"); name != "Page.txt" {
+		t.Errorf("output of the page = %q, want Page.txt", name)
+	}
+}